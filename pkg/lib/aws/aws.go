@@ -21,6 +21,8 @@ package aws
 
 import (
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -28,6 +30,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sts"
 
 	"github.com/cortexlabs/cortex/pkg/lib/errors"
@@ -38,13 +42,25 @@ import (
 type Client struct {
 	Region               string
 	Bucket               string
-	S3                   *s3.S3
+	S3                   s3iface.S3API
 	stsClient            *sts.STS
 	autoscaling          *autoscaling.AutoScaling
+	sqs                  *sqs.SQS
+	redirectedS3Clients  *sync.Map // redirectKey -> *s3.S3 in the bucket's region, shared by copies of the client
 	CloudWatchLogsClient *cloudwatchlogs.CloudWatchLogs
 	CloudWatchMetrics    *cloudwatch.CloudWatch
 	AccountID            string
 	HashedAccountID      string
+	OperationTimeout     time.Duration        // If set, each S3 call is cancelled if it takes longer than this
+	ExpectedBucketOwner  string               // If set, S3 rejects calls to the bucket if it isn't owned by this account ID
+	ServerSideEncryption string               // Encryption of written objects: AES256 (default), aws:kms, or ServerSideEncryptionNone
+	UploadKeyValidation  string               // How keys with leading or repeated slashes are uploaded (see UploadKeyValidationReject)
+	S3PartSize           int64                // The part size of multipart uploads and downloads (0 uses the SDK default)
+	S3Concurrency        int                  // The number of parts of an upload or download transferred in parallel (0 uses the SDK default)
+	S3Logger             func(S3OperationLog) // If set, called after each S3 call (e.g. to log it)
+	S3Metrics            S3MetricsRecorder    // If set, records the outcome and transferred bytes of each S3 call
+	SSECustomerKey       string               // If set, objects are written and read with this customer-provided (SSE-C) key, in raw (not base64) form
+	SSECustomerAlgorithm string               // The SSE-C algorithm (defaults to AES256)
 }
 
 var EKSSupportedRegions strset.Set
@@ -60,19 +76,35 @@ func init() {
 }
 
 func New(region string, bucket string, withAccountID bool) (*Client, error) {
+	bucketLocation, err := bucketRegion(bucket)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(region, bucket, bucketLocation, withAccountID)
+}
+
+// bucketRegion validates the bucket name, and returns the bucket's region (for access point ARNs, the ARN's region)
+func bucketRegion(bucket string) (string, error) {
+	if err := ValidateBucketName(bucket); err != nil {
+		return "", err
+	}
+	if IsS3AccessPointARN(bucket) {
+		accessPointARN, _ := parseS3AccessPointARN(bucket) // already validated
+		return accessPointARN.Region, nil
+	}
+	return GetBucketRegion(bucket)
+}
+
+func newClient(region string, bucket string, bucketLocation string, withAccountID bool) (*Client, error) {
 	sess := session.Must(session.NewSession(&aws.Config{
 		Region:     aws.String(region),
 		DisableSSL: aws.Bool(false),
 	}))
 
-	bucketLocation, err := GetBucketRegion(bucket)
-	if err != nil {
-		return nil, err
-	}
-
 	bucketSess := session.Must(session.NewSession(&aws.Config{
-		Region:     aws.String(bucketLocation),
-		DisableSSL: aws.Bool(false),
+		Region:         aws.String(bucketLocation),
+		DisableSSL:     aws.Bool(false),
+		S3UseARNRegion: aws.Bool(true), // requests to access point ARNs use the ARN's region
 	}))
 
 	awsClient := &Client{
@@ -81,8 +113,10 @@ func New(region string, bucket string, withAccountID bool) (*Client, error) {
 		S3:                   s3.New(bucketSess),
 		stsClient:            sts.New(sess),
 		autoscaling:          autoscaling.New(sess),
+		sqs:                  sqs.New(sess),
 		CloudWatchMetrics:    cloudwatch.New(sess),
 		CloudWatchLogsClient: cloudwatchlogs.New(sess),
+		redirectedS3Clients:  &sync.Map{},
 	}
 
 	if withAccountID {
@@ -97,16 +131,36 @@ func New(region string, bucket string, withAccountID bool) (*Client, error) {
 	return awsClient, nil
 }
 
+// NewWithS3Client returns a client which uses the provided S3 implementation (e.g. a fake in tests);
+// only the S3 methods of the returned client can be used
+func NewWithS3Client(region string, bucket string, s3Client s3iface.S3API) *Client {
+	return &Client{
+		Region:              region,
+		Bucket:              bucket,
+		S3:                  s3Client,
+		redirectedS3Clients: &sync.Map{},
+	}
+}
+
+// WithBucket returns a copy of the client which uses bucket, sharing the original's sessions and S3 client.
+// The bucket should be in the same region as the client's bucket
+func (c *Client) WithBucket(bucket string) *Client {
+	clientCopy := *c
+	clientCopy.Bucket = bucket
+	return &clientCopy
+}
+
+// NewFromS3Path returns a client for the path's bucket, in the bucket's region
 func NewFromS3Path(s3Path string, withAccountID bool) (*Client, error) {
 	bucket, _, err := SplitS3Path(s3Path)
 	if err != nil {
 		return nil, err
 	}
 
-	region, err := GetBucketRegion(bucket)
+	region, err := bucketRegion(bucket)
 	if err != nil {
 		return nil, err
 	}
 
-	return New(region, bucket, withAccountID)
+	return newClient(region, bucket, region, withAccountID)
 }
@@ -0,0 +1,130 @@
+/*
+Copyright 2019 Cortex Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/cortexlabs/cortex/pkg/lib/errors"
+	"github.com/cortexlabs/cortex/pkg/lib/json"
+	"github.com/cortexlabs/cortex/pkg/lib/msgpack"
+	"github.com/cortexlabs/yaml"
+)
+
+// Codec converts objects to and from the bytes stored in S3
+type Codec interface {
+	Marshal(obj interface{}) ([]byte, error)
+	Unmarshal(data []byte, objPtr interface{}) error
+	ContentType() string
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(obj interface{}) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func (jsonCodec) Unmarshal(data []byte, objPtr interface{}) error {
+	return json.Unmarshal(data, objPtr)
+}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(obj interface{}) ([]byte, error) {
+	return msgpack.Marshal(obj)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, objPtr interface{}) error {
+	return msgpack.Unmarshal(data, objPtr)
+}
+
+func (msgpackCodec) ContentType() string {
+	return "application/x-msgpack"
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(obj interface{}) ([]byte, error) {
+	return yaml.Marshal(obj)
+}
+
+func (yamlCodec) Unmarshal(data []byte, objPtr interface{}) error {
+	return yaml.Unmarshal(data, objPtr)
+}
+
+func (yamlCodec) ContentType() string {
+	return "application/x-yaml"
+}
+
+var JSONCodec Codec = jsonCodec{}
+var MsgpackCodec Codec = msgpackCodec{}
+var YAMLCodec Codec = yamlCodec{}
+
+var _codecs = struct {
+	m map[string]Codec
+	sync.RWMutex
+}{m: map[string]Codec{
+	"json":    JSONCodec,
+	"msgpack": MsgpackCodec,
+	"yaml":    YAMLCodec,
+	"yml":     YAMLCodec,
+}}
+
+func RegisterCodec(name string, codec Codec) {
+	_codecs.Lock()
+	defer _codecs.Unlock()
+	_codecs.m[name] = codec
+}
+
+func GetCodec(name string) (Codec, bool) {
+	_codecs.RLock()
+	defer _codecs.RUnlock()
+	codec, ok := _codecs.m[name]
+	return codec, ok
+}
+
+func (c *Client) WriteObject(obj interface{}, key string, codec Codec) error {
+	data, err := codec.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.UploadBytesToS3WithOptions(data, key, UploadOptions{ContentType: codec.ContentType()})
+}
+
+func (c *Client) ReadObject(objPtr interface{}, key string, codec Codec) error {
+	data, err := c.ReadBytesFromS3(key)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(codec.Unmarshal(data, objPtr), key)
+}
+
+// ReadAutoFromS3 decodes the object with the codec registered under its key's extension (e.g. "config.yaml" uses YAMLCodec)
+func (c *Client) ReadAutoFromS3(objPtr interface{}, key string) error {
+	ext := strings.TrimPrefix(path.Ext(key), ".")
+	codec, ok := GetCodec(strings.ToLower(ext))
+	if !ok {
+		return ErrorUnsupportedS3ObjectExtension(key, ext)
+	}
+	return c.ReadObject(objPtr, key, codec)
+}
@@ -17,9 +17,11 @@ limitations under the License.
 package aws
 
 import (
+	"compress/gzip"
 	"fmt"
 
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
 
 	"github.com/cortexlabs/cortex/pkg/lib/errors"
 	s "github.com/cortexlabs/cortex/pkg/lib/strings"
@@ -36,6 +38,29 @@ const (
 	ErrInstanceTypeLimitIsZero
 	ErrNoValidSpotPrices
 	ErrReadCredentials
+	ErrObjectLockNotEnabled
+	ErrInvalidObjectLockRetention
+	ErrBucketOwnerMismatch
+	ErrInvalidGzipCompressionLevel
+	ErrBucketNotConfigured
+	ErrConfusingS3Key
+	ErrInvalidS3StorageClass
+	ErrS3AccessDenied
+	ErrS3BucketNotFound
+	ErrS3Unreachable
+	ErrS3ObjectTooLarge
+	ErrPreconditionFailed
+	ErrS3ObjectEmpty
+	ErrS3PrefixEmpty
+	ErrInvalidBucketPolicy
+	ErrS3IntegrityCheckFailed
+	ErrInvalidBucketName
+	ErrUnsupportedS3ObjectExtension
+	ErrS3DeleteAllNotAllowed
+	ErrS3RedirectNotFollowed
+	ErrAlreadyExists
+	ErrInvalidParquetFile
+	ErrSQSNotConfigured
 )
 
 var errorKinds = []string{
@@ -47,9 +72,32 @@ var errorKinds = []string{
 	"err_instance_type_limit_is_zero",
 	"err_no_valid_spot_prices",
 	"err_read_credentials",
+	"err_object_lock_not_enabled",
+	"err_invalid_object_lock_retention",
+	"err_bucket_owner_mismatch",
+	"err_invalid_gzip_compression_level",
+	"err_bucket_not_configured",
+	"err_confusing_s3_key",
+	"err_invalid_s3_storage_class",
+	"err_s3_access_denied",
+	"err_s3_bucket_not_found",
+	"err_s3_unreachable",
+	"err_s3_object_too_large",
+	"err_precondition_failed",
+	"err_s3_object_empty",
+	"err_s3_prefix_empty",
+	"err_invalid_bucket_policy",
+	"err_s3_integrity_check_failed",
+	"err_invalid_bucket_name",
+	"err_unsupported_s3_object_extension",
+	"err_s3_delete_all_not_allowed",
+	"err_s3_redirect_not_followed",
+	"err_already_exists",
+	"err_invalid_parquet_file",
+	"err_sqs_not_configured",
 }
 
-var _ = [1]int{}[int(ErrReadCredentials)-(len(errorKinds)-1)] // Ensure list length matches
+var _ = [1]int{}[int(ErrSQSNotConfigured)-(len(errorKinds)-1)] // Ensure list length matches
 
 func (t ErrorKind) String() string {
 	return errorKinds[t]
@@ -97,12 +145,20 @@ func IsNoSuchBucketErr(err error) bool {
 	return CheckErrCode(err, "NoSuchBucket")
 }
 
+func IsSlowDownErr(err error) bool {
+	return CheckErrCode(err, "SlowDown")
+}
+
 func IsGenericNotFoundErr(err error) bool {
 	return IsNotFoundErr(err) || IsNoSuchKeyErr(err) || IsNoSuchBucketErr(err)
 }
 
 func CheckErrCode(err error, errorCode string) bool {
-	awsErr, ok := errors.Cause(err).(awserr.Error)
+	cause := errors.Cause(err)
+	if wrapper, ok := cause.(Error); ok && wrapper.origErr != nil {
+		cause = errors.Cause(wrapper.origErr)
+	}
+	awsErr, ok := cause.(awserr.Error)
 	if !ok {
 		return false
 	}
@@ -115,12 +171,18 @@ func CheckErrCode(err error, errorCode string) bool {
 type Error struct {
 	Kind    ErrorKind
 	message string
+	origErr error // the underlying S3 error, if any (see OrigErr)
 }
 
 func (e Error) Error() string {
 	return e.message
 }
 
+// OrigErr returns the underlying S3 error (e.g. the AccessDenied error of ErrBucketOwnerMismatch), or nil
+func (e Error) OrigErr() error {
+	return e.origErr
+}
+
 func ErrorInvalidS3aPath(provided string) error {
 	return errors.WithStack(Error{
 		Kind:    ErrInvalidS3aPath,
@@ -169,3 +231,169 @@ func ErrorReadCredentials() error {
 		message: "unable to read AWS credentials from credentials file",
 	})
 }
+
+func ErrorObjectLockNotEnabled(bucket string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrObjectLockNotEnabled,
+		message: fmt.Sprintf("object lock is not enabled on bucket \"%s\" (object lock can only be enabled when a bucket is created)", bucket),
+	})
+}
+
+func ErrorInvalidObjectLockRetention(mode string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrInvalidObjectLockRetention,
+		message: fmt.Sprintf("invalid object lock retention (mode %s): the mode must be %s, and a retain until date must be provided with it", s.UserStr(mode), s.UserStrsOr([]string{s3.ObjectLockModeGovernance, s3.ObjectLockModeCompliance})),
+	})
+}
+
+func ErrorBucketOwnerMismatch(bucket string, expectedOwner string, err error) error {
+	return errors.WithStack(Error{
+		Kind:    ErrBucketOwnerMismatch,
+		message: fmt.Sprintf("access to bucket \"%s\" was denied; either the bucket is not owned by the expected account (%s), or the request is not permitted (%s)", bucket, expectedOwner, err.Error()),
+		origErr: err,
+	})
+}
+
+func ErrorInvalidGzipCompressionLevel(level int) error {
+	return errors.WithStack(Error{
+		Kind:    ErrInvalidGzipCompressionLevel,
+		message: fmt.Sprintf("invalid gzip compression level %d: must be between %d (best speed) and %d (best compression), or %d (default)", level, gzip.BestSpeed, gzip.BestCompression, gzip.DefaultCompression),
+	})
+}
+
+func ErrorBucketNotConfigured() error {
+	return errors.WithStack(Error{
+		Kind:    ErrBucketNotConfigured,
+		message: "the S3 bucket is not configured (the client's bucket is empty)",
+	})
+}
+
+func ErrorConfusingS3Key(key string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrConfusingS3Key,
+		message: fmt.Sprintf("%s is not a valid key: keys must not start with a slash or contain consecutive slashes", s.UserStr(key)),
+	})
+}
+
+func ErrorInvalidS3StorageClass(storageClass string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrInvalidS3StorageClass,
+		message: fmt.Sprintf("invalid S3 storage class %s: must be %s", s.UserStr(storageClass), s.UserStrsOr(_s3StorageClasses)),
+	})
+}
+
+func ErrorS3AccessDenied(bucket string, operation string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3AccessDenied,
+		message: fmt.Sprintf("access to bucket \"%s\" was denied: the credentials are not permitted to %s", bucket, operation),
+	})
+}
+
+func ErrorS3BucketNotFound(bucket string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3BucketNotFound,
+		message: fmt.Sprintf("bucket \"%s\" does not exist", bucket),
+	})
+}
+
+func ErrorS3Unreachable(err error) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3Unreachable,
+		message: fmt.Sprintf("unable to reach S3 (%s)", err.Error()),
+	})
+}
+
+func ErrorS3ObjectTooLarge(key string, maxBytes int64) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3ObjectTooLarge,
+		message: fmt.Sprintf("%s is larger than the maximum of %d bytes", s.UserStr(key), maxBytes),
+	})
+}
+
+func ErrorPreconditionFailed(key string, etag string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrPreconditionFailed,
+		message: fmt.Sprintf("%s has been modified: its ETag no longer matches %s", s.UserStr(key), etag),
+	})
+}
+
+func ErrorS3ObjectEmpty(key string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3ObjectEmpty,
+		message: fmt.Sprintf("%s is empty", s.UserStr(key)),
+	})
+}
+
+func ErrorS3PrefixEmpty(prefix string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3PrefixEmpty,
+		message: fmt.Sprintf("no objects were found under %s", s.UserStr(prefix)),
+	})
+}
+
+func ErrorInvalidBucketPolicy(bucket string, err error) error {
+	return errors.WithStack(Error{
+		Kind:    ErrInvalidBucketPolicy,
+		message: fmt.Sprintf("the policy for bucket \"%s\" is not valid JSON (%s)", bucket, err.Error()),
+	})
+}
+
+func ErrorS3IntegrityCheckFailed(key string, uploadedBytes int, readBytes int) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3IntegrityCheckFailed,
+		message: fmt.Sprintf("%s was not stored correctly: the content read back (%d bytes) does not match the content uploaded (%d bytes)", s.UserStr(key), readBytes, uploadedBytes),
+	})
+}
+
+func ErrorInvalidBucketName(bucket string, reason string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrInvalidBucketName,
+		message: fmt.Sprintf("\"%s\" is not a valid bucket name: %s", bucket, reason),
+	})
+}
+
+func ErrorUnsupportedS3ObjectExtension(key string, ext string) error {
+	message := fmt.Sprintf("%s: unable to determine how to decode the object (no codec is registered for the \"%s\" extension)", key, ext)
+	if ext == "" {
+		message = fmt.Sprintf("%s: unable to determine how to decode the object (it has no file extension)", key)
+	}
+	return errors.WithStack(Error{
+		Kind:    ErrUnsupportedS3ObjectExtension,
+		message: message,
+	})
+}
+
+func ErrorS3DeleteAllNotAllowed(bucket string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3DeleteAllNotAllowed,
+		message: fmt.Sprintf("refusing to delete by an empty prefix, which would delete every object in the %s bucket", bucket),
+	})
+}
+
+func ErrorS3RedirectNotFollowed(key string, location string, reason string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrS3RedirectNotFollowed,
+		message: fmt.Sprintf("%s: unable to follow the redirect to %s because %s", key, location, reason),
+	})
+}
+
+func ErrorAlreadyExists(key string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrAlreadyExists,
+		message: fmt.Sprintf("%s already exists", s.UserStr(key)),
+	})
+}
+
+func ErrorInvalidParquetFile(key string, reason string) error {
+	return errors.WithStack(Error{
+		Kind:    ErrInvalidParquetFile,
+		message: fmt.Sprintf("%s is not a valid Parquet file: %s", s.UserStr(key), reason),
+	})
+}
+
+func ErrorSQSNotConfigured() error {
+	return errors.WithStack(Error{
+		Kind:    ErrSQSNotConfigured,
+		message: "the SQS client is not configured (clients created from an S3 client can't watch for S3 changes)",
+	})
+}
@@ -0,0 +1,418 @@
+/*
+Copyright 2019 Cortex Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cortexlabs/cortex/pkg/lib/errors"
+)
+
+// ParquetColumn is a leaf column of a Parquet schema; nested columns are named by their dot-separated path
+type ParquetColumn struct {
+	Name          string
+	Type          string // the physical type (e.g. INT64 or BYTE_ARRAY)
+	Repetition    string // REQUIRED, OPTIONAL, or REPEATED
+	ConvertedType string // e.g. UTF8 or TIMESTAMP_MILLIS ("" if not set)
+}
+
+var _parquetMagic = []byte("PAR1")
+
+// footers are typically a few KB, so a larger length is treated as corrupt rather than allocated
+var _parquetMaxFooterLength int64 = 16 * 1024 * 1024
+
+var _parquetTypes = []string{"BOOLEAN", "INT32", "INT64", "INT96", "FLOAT", "DOUBLE", "BYTE_ARRAY", "FIXED_LEN_BYTE_ARRAY"}
+
+var _parquetRepetitions = []string{"REQUIRED", "OPTIONAL", "REPEATED"}
+
+var _parquetConvertedTypes = []string{
+	"UTF8", "MAP", "MAP_KEY_VALUE", "LIST", "ENUM", "DECIMAL", "DATE", "TIME_MILLIS", "TIME_MICROS", "TIMESTAMP_MILLIS",
+	"TIMESTAMP_MICROS", "UINT_8", "UINT_16", "UINT_32", "UINT_64", "INT_8", "INT_16", "INT_32", "INT_64", "JSON", "BSON", "INTERVAL",
+}
+
+// ReadParquetSchemaFromS3 returns the leaf columns of a Parquet object's schema. Only the footer is read (with ranged GETs),
+// so the size of the object doesn't matter
+func (c *Client) ReadParquetSchemaFromS3(key string) ([]ParquetColumn, error) {
+	readSeeker, size, err := c.NewS3ReadSeeker(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// a Parquet file ends with the footer, the footer's length (4 bytes, little-endian), and "PAR1"
+	if size < int64(2*len(_parquetMagic)+4) {
+		return nil, ErrorInvalidParquetFile(key, "it is too small")
+	}
+	tail := make([]byte, 8)
+	if _, err := readSeeker.Seek(-8, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(readSeeker, tail); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != string(_parquetMagic) {
+		return nil, ErrorInvalidParquetFile(key, "it doesn't end with the Parquet magic number")
+	}
+
+	footerLength := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLength > size-int64(2*len(_parquetMagic)+4) {
+		return nil, ErrorInvalidParquetFile(key, fmt.Sprintf("its footer length (%d bytes) is larger than the file", footerLength))
+	}
+	if footerLength > _parquetMaxFooterLength {
+		return nil, ErrorInvalidParquetFile(key, fmt.Sprintf("its footer length (%d bytes) is larger than the maximum (%d bytes)", footerLength, _parquetMaxFooterLength))
+	}
+	footer := make([]byte, footerLength)
+	if _, err := readSeeker.Seek(-8-footerLength, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(readSeeker, footer); err != nil {
+		return nil, err
+	}
+
+	columns, err := parseParquetSchema(footer)
+	if err != nil {
+		return nil, ErrorInvalidParquetFile(key, err.Error())
+	}
+	return columns, nil
+}
+
+type parquetSchemaElement struct {
+	name          string
+	physicalType  *int32
+	repetition    *int32
+	convertedType *int32
+	numChildren   int32
+}
+
+// parseParquetSchema decodes the schema (field 2) of the footer's FileMetaData, skipping every other field
+func parseParquetSchema(footer []byte) ([]ParquetColumn, error) {
+	reader := &thriftCompactReader{data: footer}
+
+	var elements []parquetSchemaElement
+	err := reader.readStruct(func(fieldID int16, fieldType byte) error {
+		if fieldID != 2 || fieldType != thriftTypeList {
+			return reader.skip(fieldType)
+		}
+
+		size, elemType, err := reader.readListHeader()
+		if err != nil {
+			return err
+		}
+		if elemType != thriftTypeStruct {
+			return errors.New("the schema is not a list of structs")
+		}
+		for i := 0; i < size; i++ {
+			element, err := readParquetSchemaElement(reader)
+			if err != nil {
+				return err
+			}
+			elements = append(elements, element)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(elements) == 0 {
+		return nil, errors.New("the footer has no schema")
+	}
+
+	// the schema is flattened depth-first, and the first element is the root
+	var columns []ParquetColumn
+	next := 1
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		if next >= len(elements) {
+			return errors.New("the schema has fewer elements than its groups declare")
+		}
+		element := elements[next]
+		next++
+		path = append(path, element.name)
+
+		if element.numChildren == 0 {
+			columns = append(columns, ParquetColumn{
+				Name:          strings.Join(path, "."),
+				Type:          parquetEnumString(_parquetTypes, element.physicalType),
+				Repetition:    parquetEnumString(_parquetRepetitions, element.repetition),
+				ConvertedType: parquetEnumString(_parquetConvertedTypes, element.convertedType),
+			})
+			return nil
+		}
+		for i := int32(0); i < element.numChildren; i++ {
+			if err := walk(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for i := int32(0); i < elements[0].numChildren; i++ {
+		if err := walk(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return columns, nil
+}
+
+func readParquetSchemaElement(reader *thriftCompactReader) (parquetSchemaElement, error) {
+	var element parquetSchemaElement
+	err := reader.readStruct(func(fieldID int16, fieldType byte) error {
+		var err error
+		switch {
+		case fieldID == 1 && fieldType == thriftTypeI32:
+			element.physicalType, err = reader.readI32Ptr()
+		case fieldID == 3 && fieldType == thriftTypeI32:
+			element.repetition, err = reader.readI32Ptr()
+		case fieldID == 4 && fieldType == thriftTypeBinary:
+			var name []byte
+			name, err = reader.readBinary()
+			element.name = string(name)
+		case fieldID == 5 && fieldType == thriftTypeI32:
+			var numChildren *int32
+			numChildren, err = reader.readI32Ptr()
+			if err == nil {
+				element.numChildren = *numChildren
+			}
+		case fieldID == 6 && fieldType == thriftTypeI32:
+			element.convertedType, err = reader.readI32Ptr()
+		default:
+			err = reader.skip(fieldType)
+		}
+		return err
+	})
+	return element, err
+}
+
+func parquetEnumString(names []string, value *int32) string {
+	if value == nil {
+		return ""
+	}
+	if *value < 0 || int(*value) >= len(names) {
+		return fmt.Sprintf("UNKNOWN(%d)", *value)
+	}
+	return names[*value]
+}
+
+// Thrift compact protocol types (https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md)
+const (
+	thriftTypeStop      byte = 0
+	thriftTypeBoolTrue  byte = 1
+	thriftTypeBoolFalse byte = 2
+	thriftTypeByte      byte = 3
+	thriftTypeI16       byte = 4
+	thriftTypeI32       byte = 5
+	thriftTypeI64       byte = 6
+	thriftTypeDouble    byte = 7
+	thriftTypeBinary    byte = 8
+	thriftTypeList      byte = 9
+	thriftTypeSet       byte = 10
+	thriftTypeMap       byte = 11
+	thriftTypeStruct    byte = 12
+)
+
+var _thriftMaxDepth = 64 // nested structs and containers; guards against malformed (or malicious) footers
+
+// thriftCompactReader decodes the subset of the Thrift compact protocol which is needed to read Parquet footers
+type thriftCompactReader struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (r *thriftCompactReader) readByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftCompactReader) readVarint() (uint64, error) {
+	var value uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+		value |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return value, nil
+		}
+	}
+	return 0, errors.New("varint is too long")
+}
+
+func (r *thriftCompactReader) readZigzag() (int64, error) {
+	value, err := r.readVarint()
+	if err != nil {
+		return 0, err
+	}
+	return int64(value>>1) ^ -int64(value&1), nil
+}
+
+func (r *thriftCompactReader) readI32Ptr() (*int32, error) {
+	value, err := r.readZigzag()
+	if err != nil {
+		return nil, err
+	}
+	i32 := int32(value)
+	return &i32, nil
+}
+
+func (r *thriftCompactReader) readBinary() ([]byte, error) {
+	length, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > uint64(len(r.data)-r.pos) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	value := r.data[r.pos : r.pos+int(length)]
+	r.pos += int(length)
+	return value, nil
+}
+
+func (r *thriftCompactReader) readListHeader() (int, byte, error) {
+	header, err := r.readByte()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := uint64(header >> 4)
+	if size == 15 {
+		if size, err = r.readVarint(); err != nil {
+			return 0, 0, err
+		}
+	}
+	// each element takes at least one byte, so a larger size can't be valid
+	if size > uint64(len(r.data)-r.pos) {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	return int(size), header & 0x0f, nil
+}
+
+// readStruct calls fn with each field's ID and type; fn must read (or skip) the field's value
+func (r *thriftCompactReader) readStruct(fn func(fieldID int16, fieldType byte) error) error {
+	if r.depth++; r.depth > _thriftMaxDepth {
+		return errors.New("thrift structs are nested too deeply")
+	}
+	defer func() { r.depth-- }()
+
+	var lastFieldID int16
+	for {
+		header, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		fieldType := header & 0x0f
+		if fieldType == thriftTypeStop {
+			return nil
+		}
+
+		fieldID := lastFieldID + int16(header>>4)
+		if header>>4 == 0 {
+			id, err := r.readZigzag()
+			if err != nil {
+				return err
+			}
+			fieldID = int16(id)
+		}
+		lastFieldID = fieldID
+
+		if err := fn(fieldID, fieldType); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *thriftCompactReader) skip(fieldType byte) error {
+	switch fieldType {
+	case thriftTypeBoolTrue, thriftTypeBoolFalse:
+		return nil // a struct field's boolean value is its type
+	case thriftTypeByte:
+		_, err := r.readByte()
+		return err
+	case thriftTypeI16, thriftTypeI32, thriftTypeI64:
+		_, err := r.readVarint()
+		return err
+	case thriftTypeDouble:
+		if len(r.data)-r.pos < 8 {
+			return io.ErrUnexpectedEOF
+		}
+		r.pos += 8
+		return nil
+	case thriftTypeBinary:
+		_, err := r.readBinary()
+		return err
+	case thriftTypeList, thriftTypeSet:
+		size, elemType, err := r.readListHeader()
+		if err != nil {
+			return err
+		}
+		return r.skipElements(size, elemType)
+	case thriftTypeMap:
+		size, err := r.readVarint()
+		if err != nil || size == 0 {
+			return err
+		}
+		if size > uint64(len(r.data)-r.pos) {
+			return io.ErrUnexpectedEOF
+		}
+		kvTypes, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < size; i++ {
+			if err := r.skipElements(1, kvTypes>>4); err != nil {
+				return err
+			}
+			if err := r.skipElements(1, kvTypes&0x0f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftTypeStruct:
+		return r.readStruct(func(_ int16, fieldType byte) error {
+			return r.skip(fieldType)
+		})
+	default:
+		return errors.New(fmt.Sprintf("unknown thrift type %d", fieldType))
+	}
+}
+
+// skipElements skips container elements, which (unlike struct fields) store booleans as a byte
+func (r *thriftCompactReader) skipElements(count int, elemType byte) error {
+	if r.depth++; r.depth > _thriftMaxDepth {
+		return errors.New("thrift containers are nested too deeply")
+	}
+	defer func() { r.depth-- }()
+
+	for i := 0; i < count; i++ {
+		var err error
+		if elemType == thriftTypeBoolTrue || elemType == thriftTypeBoolFalse {
+			_, err = r.readByte()
+		} else {
+			err = r.skip(elemType)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,30 +17,105 @@ limitations under the License.
 package aws
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/ugorji/go/codec"
 
 	"github.com/cortexlabs/cortex/pkg/lib/errors"
 	"github.com/cortexlabs/cortex/pkg/lib/files"
 	"github.com/cortexlabs/cortex/pkg/lib/json"
+	"github.com/cortexlabs/cortex/pkg/lib/maps"
 	"github.com/cortexlabs/cortex/pkg/lib/msgpack"
 	"github.com/cortexlabs/cortex/pkg/lib/parallel"
 	"github.com/cortexlabs/cortex/pkg/lib/sets/strset"
+	"github.com/cortexlabs/cortex/pkg/lib/slices"
 	s "github.com/cortexlabs/cortex/pkg/lib/strings"
+	"github.com/cortexlabs/cortex/pkg/lib/zstd"
 )
 
 const DefaultS3Region string = endpoints.UsWest2RegionID
 
+// UploadKeyValidation settings, for keys with leading or repeated slashes (e.g. "/models//x"), which are usually accidental
+const (
+	UploadKeyValidationNone      = ""
+	UploadKeyValidationReject    = "reject"
+	UploadKeyValidationNormalize = "normalize"
+)
+
+// ServerSideEncryptionNone disables server-side encryption of written objects (e.g. for S3-compatible stores which don't support it)
+const ServerSideEncryptionNone = "none"
+
 var S3Regions strset.Set
 
+var _s3BatchConcurrency = 32 // the maximum number of concurrent requests made by batch operations
+var _s3MaxDeleteObjects = 1000
+
+var _largeDownloadPartSize int64 = 64 * 1024 * 1024
+var _largeDownloadConcurrency = 16
+
+var _resumableReadMaxResumes = 5
+
+var _s3MinPartSize int64 = 5 * 1024 * 1024            // the minimum size of every part of a multipart upload except the last
+var _s3MaxCopyPartSize int64 = 5 * 1024 * 1024 * 1024 // the maximum size of a part copied with UploadPartCopy
+
+var _multipartPartRetryInitialDelay = 500 * time.Millisecond
+var _multipartPartRetryMaxDelay = 10 * time.Second
+
+var _s3ThrottleMaxRetries = 5
+var _s3ThrottleInitialDelay = 200 * time.Millisecond
+var _s3ThrottleMaxDelay = 5 * time.Second
+
+var _isS3FileEventuallyInitialDelay = 100 * time.Millisecond
+var _isS3FileEventuallyMaxDelay = 2 * time.Second
+
+var _waitForBucketInitialDelay = 200 * time.Millisecond
+var _waitForBucketMaxDelay = 5 * time.Second
+
+var _s3StorageClasses = []string{
+	s3.StorageClassStandard,
+	s3.StorageClassReducedRedundancy,
+	s3.StorageClassStandardIa,
+	s3.StorageClassOnezoneIa,
+	s3.StorageClassIntelligentTiering,
+	s3.StorageClassGlacier,
+	s3.StorageClassDeepArchive,
+}
+
 func init() {
 	resolver := endpoints.DefaultResolver()
 	partitions := resolver.(endpoints.EnumPartitions).Partitions()
@@ -56,24 +131,417 @@ func init() {
 	}
 }
 
+// WithOperationTimeout returns a copy of the client which uses the provided timeout for each S3 call (0 disables the timeout)
+func (c *Client) WithOperationTimeout(timeout time.Duration) *Client {
+	clientCopy := *c
+	clientCopy.OperationTimeout = timeout
+	return &clientCopy
+}
+
+func (c *Client) s3Context() (aws.Context, context.CancelFunc) {
+	return c.s3ContextWithParent(aws.BackgroundContext())
+}
+
+func (c *Client) s3ContextWithParent(parent aws.Context) (aws.Context, context.CancelFunc) {
+	if c.OperationTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, c.OperationTimeout)
+}
+
+// operationTimeoutOption applies OperationTimeout to each request of an s3manager transfer (until a GetObject's body is closed)
+func (c *Client) operationTimeoutOption() request.Option {
+	return func(r *request.Request) {
+		ctx, cancel := c.s3ContextWithParent(r.Context())
+		r.SetContext(ctx)
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if output, ok := r.Data.(*s3.GetObjectOutput); ok && r.Error == nil && output.Body != nil {
+				output.Body = &cancelOnCloseReader{ReadCloser: output.Body, cancel: cancel}
+				return
+			}
+			cancel()
+		})
+	}
+}
+
+type cancelOnCloseReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r *cancelOnCloseReader) Close() error {
+	defer r.cancel()
+	return r.ReadCloser.Close()
+}
+
+// uploadKey applies the client's UploadKeyValidation to a key which is about to be written
+func (c *Client) uploadKey(key string) (string, error) {
+	if c.UploadKeyValidation == UploadKeyValidationNone || !hasConfusingSlashes(key) {
+		return key, nil
+	}
+	if c.UploadKeyValidation == UploadKeyValidationNormalize {
+		return S3KeyJoin(key), nil
+	}
+	return "", ErrorConfusingS3Key(key)
+}
+
+func hasConfusingSlashes(key string) bool {
+	return strings.HasPrefix(key, "/") || strings.Contains(key, "//")
+}
+
+func (c *Client) serverSideEncryption() *string {
+	if c.SSECustomerKey != "" {
+		return nil // S3 rejects requests which set both
+	}
+	return serverSideEncryptionValue(c.ServerSideEncryption)
+}
+
+func serverSideEncryptionValue(setting string) *string {
+	switch setting {
+	case "":
+		return aws.String(s3.ServerSideEncryptionAes256)
+	case ServerSideEncryptionNone:
+		return nil
+	default:
+		return aws.String(setting)
+	}
+}
+
+// s3RequestOptions are applied to every S3 request made by the client
+func (c *Client) s3RequestOptions() []request.Option {
+	var options []request.Option
+
+	if c.Bucket == "" {
+		options = append(options, func(r *request.Request) {
+			// runs before the SDK's parameter validation, which would report a less helpful error
+			r.Handlers.Validate.PushFront(func(r *request.Request) {
+				r.Error = ErrorBucketNotConfigured()
+			})
+		})
+	}
+
+	if c.ExpectedBucketOwner != "" {
+		bucket, expectedOwner := c.Bucket, c.ExpectedBucketOwner
+		options = append(options, func(r *request.Request) {
+			r.Handlers.Build.PushBack(func(r *request.Request) {
+				r.HTTPRequest.Header.Set("X-Amz-Expected-Bucket-Owner", expectedOwner)
+			})
+			// the Retry handlers run whenever an attempt fails; S3 reports an owner mismatch as AccessDenied, so only
+			// those errors (for requests which were sent with the expected owner) may be due to the owner check
+			r.Handlers.Retry.PushBack(func(r *request.Request) {
+				reqErr, ok := r.Error.(awserr.RequestFailure)
+				if !ok || reqErr.StatusCode() != http.StatusForbidden || reqErr.Code() != "AccessDenied" {
+					return
+				}
+				if r.HTTPRequest == nil || r.HTTPRequest.Header.Get("X-Amz-Expected-Bucket-Owner") == "" {
+					return
+				}
+				r.Error = ErrorBucketOwnerMismatch(bucket, expectedOwner, reqErr)
+			})
+		})
+	}
+
+	if c.SSECustomerKey != "" {
+		sseFields := sseCustomerKeyFields(c.SSECustomerAlgorithm, c.SSECustomerKey)
+		options = append(options, func(r *request.Request) {
+			r.Handlers.Validate.PushFront(func(r *request.Request) {
+				setStringFields(r.Params, sseFields)
+			})
+		})
+	}
+
+	options = append(options, c.regionRedirectOption())
+
+	return append(options, c.s3HookOptions()...)
+}
+
+// sseCustomerKeyFields returns the SSE-C fields of request inputs, including the copy source's for copies within the bucket
+func sseCustomerKeyFields(algorithm string, key string) map[string]string {
+	if algorithm == "" {
+		algorithm = s3.ServerSideEncryptionAes256
+	}
+	keyMD5 := md5.Sum([]byte(key))
+	encodedKeyMD5 := base64.StdEncoding.EncodeToString(keyMD5[:])
+
+	return map[string]string{
+		"SSECustomerAlgorithm":           algorithm,
+		"SSECustomerKey":                 key,
+		"SSECustomerKeyMD5":              encodedKeyMD5,
+		"CopySourceSSECustomerAlgorithm": algorithm,
+		"CopySourceSSECustomerKey":       key,
+		"CopySourceSSECustomerKeyMD5":    encodedKeyMD5,
+	}
+}
+
+// setStringFields sets the *string fields of the struct pointed to by params which exist and are unset
+func setStringFields(params interface{}, fields map[string]string) {
+	value := reflect.ValueOf(params)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return
+	}
+	for name, fieldValue := range fields {
+		field := value.Elem().FieldByName(name)
+		if field.IsValid() && field.CanSet() && field.Type() == reflect.TypeOf((*string)(nil)) && field.IsNil() {
+			field.Set(reflect.ValueOf(aws.String(fieldValue)))
+		}
+	}
+}
+
+// S3OperationLog describes a completed S3 call. Duration includes retries, but not the reading of streamed response bodies
+type S3OperationLog struct {
+	Operation string
+	Bucket    string
+	Key       string
+	Duration  time.Duration
+	Err       error
+}
+
+// S3MetricsRecorder receives every S3 call made by a client, with its transferred bytes and whether it succeeded
+type S3MetricsRecorder interface {
+	RecordS3Operation(operation string, bytes int64, success bool)
+}
+
+// NoopS3MetricsRecorder discards all S3 metrics
+type NoopS3MetricsRecorder struct{}
+
+func (NoopS3MetricsRecorder) RecordS3Operation(operation string, bytes int64, success bool) {}
+
+// s3HookOptions returns the request options which report calls to the client's hooks (none if no hooks are set)
+func (c *Client) s3HookOptions() []request.Option {
+	var options []request.Option
+
+	if c.S3Logger != nil {
+		logger := c.S3Logger
+		options = append(options, func(r *request.Request) {
+			r.Handlers.Complete.PushBack(func(r *request.Request) {
+				logger(S3OperationLog{
+					Operation: r.Operation.Name,
+					Bucket:    s3ParamString(r.Params, "Bucket"),
+					Key:       s3ParamString(r.Params, "Key"),
+					Duration:  time.Since(r.Time),
+					Err:       r.Error,
+				})
+			})
+		})
+	}
+
+	if c.S3Metrics != nil {
+		recorder := c.S3Metrics
+		options = append(options, func(r *request.Request) {
+			r.Handlers.Complete.PushBack(func(r *request.Request) {
+				recorder.RecordS3Operation(r.Operation.Name, s3TransferredBytes(r), r.Error == nil)
+			})
+		})
+	}
+
+	return options
+}
+
+func s3TransferredBytes(r *request.Request) int64 {
+	if r.Error != nil {
+		return 0
+	}
+	if output, ok := r.Data.(*s3.GetObjectOutput); ok {
+		return aws.Int64Value(output.ContentLength)
+	}
+	if r.HTTPRequest != nil && r.HTTPRequest.ContentLength > 0 {
+		return r.HTTPRequest.ContentLength
+	}
+	return 0
+}
+
+// s3ParamString returns the value of the named *string field of an SDK input struct, or "" if it doesn't have one
+func s3ParamString(params interface{}, field string) string {
+	value := reflect.Indirect(reflect.ValueOf(params))
+	if value.Kind() != reflect.Struct {
+		return ""
+	}
+	fieldValue := value.FieldByName(field)
+	if !fieldValue.IsValid() {
+		return ""
+	}
+	str, ok := fieldValue.Interface().(*string)
+	if !ok {
+		return ""
+	}
+	return aws.StringValue(str)
+}
+
+func (c *Client) s3Uploader() *s3manager.Uploader {
+	return s3manager.NewUploaderWithClient(c.s3API(), func(u *s3manager.Uploader) {
+		u.RequestOptions = append(c.s3RequestOptions(), c.operationTimeoutOption())
+		if c.S3PartSize > 0 {
+			u.PartSize = c.S3PartSize
+		}
+		if c.S3Concurrency > 0 {
+			u.Concurrency = c.S3Concurrency
+		}
+	})
+}
+
+func (c *Client) s3Downloader(options ...func(*s3manager.Downloader)) *s3manager.Downloader {
+	return s3manager.NewDownloaderWithClient(c.s3API(), append([]func(*s3manager.Downloader){func(d *s3manager.Downloader) {
+		d.RequestOptions = append(c.s3RequestOptions(), c.operationTimeoutOption())
+		if c.S3PartSize > 0 {
+			d.PartSize = c.S3PartSize
+		}
+		if c.S3Concurrency > 0 {
+			d.Concurrency = c.S3Concurrency
+		}
+	}}, options...)...)
+}
+
+var _bucketRegionErrRegex = regexp.MustCompile(`bucket is in '([a-z0-9-]+)' region`)
+
+// bucketRegionFromErr returns the bucket's region from a BucketRegionError (the SDK's form of S3's 301 PermanentRedirect)
+func bucketRegionFromErr(err error) string {
+	if !CheckErrCode(err, "BucketRegionError") {
+		return ""
+	}
+	matches := _bucketRegionErrRegex.FindStringSubmatch(errors.Cause(err).Error())
+	if len(matches) != 2 {
+		return ""
+	}
+	return matches[1]
+}
+
+type redirectKey struct {
+	client *s3.S3
+	bucket string
+}
+
+// s3API returns the client's S3 handle, or its redirected copy for c.Bucket (see regionRedirectOption)
+func (c *Client) s3API() s3iface.S3API {
+	if sdkClient, ok := c.S3.(*s3.S3); ok && c.redirectedS3Clients != nil {
+		if redirected, ok := c.redirectedS3Clients.Load(redirectKey{sdkClient, c.Bucket}); ok {
+			return redirected.(*s3.S3)
+		}
+	}
+	return c.S3
+}
+
+// redirectedS3Client returns (and caches) a copy of the S3 handle in the bucket's region, if the request failed with a redirect
+func (c *Client) redirectedS3Client(r *request.Request) *s3.S3 {
+	region := bucketRegionFromErr(r.Error)
+	originalClient, ok := c.S3.(*s3.S3)
+	if region == "" || !ok || region == aws.StringValue(r.Config.Region) {
+		return nil
+	}
+
+	key := redirectKey{originalClient, c.Bucket}
+	if c.redirectedS3Clients != nil {
+		if redirected, ok := c.redirectedS3Clients.Load(key); ok && aws.StringValue(redirected.(*s3.S3).Config.Region) == region {
+			return redirected.(*s3.S3)
+		}
+	}
+
+	// the session isn't created with session.NewSession, which would reload the environment's config (and could modify the
+	// HTTP client that's shared with the original). s3.New adds the S3 handlers, which the original's handlers already include
+	redirectedClient := s3.New(&session.Session{
+		Config: originalClient.Config.Copy(&aws.Config{Region: aws.String(region)}),
+	})
+	redirectedClient.Handlers = originalClient.Handlers.Copy()
+	if c.redirectedS3Clients != nil {
+		c.redirectedS3Clients.Store(key, redirectedClient)
+	}
+	return redirectedClient
+}
+
+const _regionRedirectHandlerName = "cortex.RegionRedirectHandler"
+
+// regionRedirectOption re-sends the request to the bucket's region if S3 reports that the bucket is in a different region
+func (c *Client) regionRedirectOption() request.Option {
+	return func(r *request.Request) {
+		// switches the client's S3 handle even if the request can't be re-sent (see unseekableBodyOption)
+		r.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+			c.redirectedS3Client(r)
+		})
+		r.Handlers.AfterRetry.PushBackNamed(request.NamedHandler{Name: _regionRedirectHandlerName, Fn: func(r *request.Request) {
+			redirectedClient := c.redirectedS3Client(r)
+			if redirectedClient == nil || r.HTTPRequest == nil {
+				return
+			}
+			originalURL, err := url.Parse(r.ClientInfo.Endpoint)
+			if err != nil || !strings.HasSuffix(r.HTTPRequest.URL.Host, originalURL.Host) {
+				return
+			}
+			redirectedURL, err := url.Parse(redirectedClient.Endpoint)
+			if err != nil {
+				return
+			}
+
+			r.HTTPRequest.URL.Host = strings.TrimSuffix(r.HTTPRequest.URL.Host, originalURL.Host) + redirectedURL.Host
+			r.Config.Region = redirectedClient.Config.Region
+			r.ClientInfo.Endpoint = redirectedClient.Endpoint
+			r.ClientInfo.SigningRegion = redirectedClient.SigningRegion
+			r.Error = nil
+			r.Retryable = aws.Bool(true)
+		}})
+	}
+}
+
 func (c *Client) S3Path(key string) string {
-	return "s3://" + filepath.Join(c.Bucket, key)
+	return "s3://" + S3KeyJoin(c.Bucket, key)
+}
+
+type S3PathWithRegion struct {
+	Path   string
+	Region string
 }
 
+// S3PathWithRegion returns the key's s3 path along with the region of the client's S3 handle (the bucket's region)
+func (c *Client) S3PathWithRegion(key string) S3PathWithRegion {
+	region := c.Region
+	if sdkClient, ok := c.s3API().(*s3.S3); ok && aws.StringValue(sdkClient.Config.Region) != "" {
+		region = aws.StringValue(sdkClient.Config.Region)
+	}
+
+	return S3PathWithRegion{
+		Path:   c.S3Path(key),
+		Region: region,
+	}
+}
+
+// S3PathJoin joins an s3 path (e.g. s3://bucket/dir) with additional segments (see S3KeyJoin)
 func S3PathJoin(paths ...string) string {
 	if len(paths) == 0 {
 		return ""
 	}
-	paths[0] = paths[0][5:]
-	return "s3://" + filepath.Join(paths...)
+	segments := append([]string{strings.TrimPrefix(paths[0], "s3://")}, paths[1:]...)
+	return "s3://" + S3KeyJoin(segments...)
+}
+
+// S3KeyJoin joins key segments with "/", dropping empty segments and repeated slashes (a trailing slash is preserved)
+func S3KeyJoin(segments ...string) string {
+	var parts []string
+	trailingSlash := false
+	for _, segment := range segments {
+		if segment == "" {
+			continue
+		}
+		trailingSlash = strings.HasSuffix(segment, "/")
+		for _, part := range strings.Split(segment, "/") {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	key := strings.Join(parts, "/")
+	if trailingSlash && key != "" {
+		key += "/"
+	}
+	return key
 }
 
 func (c *Client) IsS3File(keys ...string) (bool, error) {
 	for _, key := range keys {
-		_, err := c.S3.HeadObject(&s3.HeadObjectInput{
+		ctx, cancel := c.s3Context()
+		output, err := c.s3API().HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 			Bucket: aws.String(c.Bucket),
 			Key:    aws.String(key),
-		})
+		}, c.s3RequestOptions()...)
+		cancel()
 
 		if IsNotFoundErr(err) {
 			return false, nil
@@ -81,231 +549,2946 @@ func (c *Client) IsS3File(keys ...string) (bool, error) {
 		if err != nil {
 			return false, errors.Wrap(err, key)
 		}
+		if isS3DirMarker(key, aws.Int64Value(output.ContentLength)) {
+			return false, nil
+		}
 	}
 
 	return true, nil
 }
 
+// A directory marker is a zero-byte object whose key ends in "/" (as created by the S3 console)
+func isS3DirMarker(key string, size int64) bool {
+	return strings.HasSuffix(key, "/") && size == 0
+}
+
+func (c *Client) IsS3DirMarker(key string) (bool, error) {
+	metadata, err := c.GetS3ObjectMetadata(key)
+	if IsNotFoundErr(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isS3DirMarker(key, metadata.Size), nil
+}
+
+// CreateS3DirMarker creates a directory marker for dirKey (a trailing "/" is added if necessary)
+func (c *Client) CreateS3DirMarker(dirKey string) error {
+	if !strings.HasSuffix(dirKey, "/") {
+		dirKey += "/"
+	}
+	return c.UploadBytesToS3([]byte{}, dirKey)
+}
+
+// IsS3FileEventually polls for the key with backoff, and returns false if it doesn't appear within the window
+func (c *Client) IsS3FileEventually(key string, within time.Duration) (bool, error) {
+	deadline := time.Now().Add(within)
+	delay := _isS3FileEventuallyInitialDelay
+
+	for {
+		exists, err := c.IsS3File(key)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return true, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, nil
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > _isS3FileEventuallyMaxDelay {
+			delay = _isS3FileEventuallyMaxDelay
+		}
+	}
+}
+
 func (c *Client) IsS3Prefix(prefixes ...string) (bool, error) {
 	for _, prefix := range prefixes {
-		out, err := c.S3.ListObjectsV2(&s3.ListObjectsV2Input{
-			Bucket: aws.String(c.Bucket),
-			Prefix: aws.String(prefix),
-		})
+		exists, err := c.isS3Prefix(prefix)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// S3PrefixesExist checks (in parallel) whether there are objects under each of the prefixes
+func (c *Client) S3PrefixesExist(prefixes ...string) (map[string]bool, error) {
+	exists := make(map[string]bool, len(prefixes))
+	var mux sync.Mutex
+
+	fns := make([]func() error, len(prefixes))
+	for i, prefix := range prefixes {
+		prefix := prefix
+		fns[i] = func() error {
+			prefixExists, err := c.isS3Prefix(prefix)
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			exists[prefix] = prefixExists
+			mux.Unlock()
+			return nil
+		}
+	}
+
+	if err := parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...); err != nil {
+		return nil, err
+	}
+	return exists, nil
+}
+
+// isS3Prefix checks both Contents and CommonPrefixes, and follows one continuation token (some S3-compatible stores need it)
+func (c *Client) isS3Prefix(prefix string) (bool, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1),
+	}
+
+	for page := 0; page < 2; page++ {
+		ctx, cancel := c.s3Context()
+		out, err := c.s3API().ListObjectsV2WithContext(ctx, input, c.s3RequestOptions()...)
+		cancel()
 
 		if err != nil {
 			return false, errors.Wrap(err, prefix)
 		}
 
-		if *out.KeyCount == 0 {
+		if len(out.Contents) > 0 || len(out.CommonPrefixes) > 0 {
+			return true, nil
+		}
+
+		if !aws.BoolValue(out.IsTruncated) || out.NextContinuationToken == nil {
 			return false, nil
 		}
+		input.ContinuationToken = out.NextContinuationToken
 	}
 
-	return true, nil
+	return false, nil
 }
 
+// IsS3Dir returns whether there are objects under each of the directories ("" is the root of the bucket)
 func (c *Client) IsS3Dir(dirPaths ...string) (bool, error) {
 	fullDirPaths := make([]string, len(dirPaths))
 	for i, dirPath := range dirPaths {
+		if dirPath == "" {
+			// "/" would only match keys which start with a slash
+			continue
+		}
 		fullDirPaths[i] = s.EnsureSuffix(dirPath, "/")
 	}
 	return c.IsS3Prefix(fullDirPaths...)
 }
 
-func (c *Client) IsS3PathFile(s3Paths ...string) (bool, error) {
-	keys, err := c.ExractS3PathPrefixes(s3Paths...)
+func (c *Client) IsS3PathFile(s3Paths ...string) (bool, error) {
+	keys, err := c.ExractS3PathPrefixes(s3Paths...)
+	if err != nil {
+		return false, err
+	}
+	return c.IsS3File(keys...)
+}
+
+func (c *Client) IsS3PathPrefix(s3Paths ...string) (bool, error) {
+	prefixes, err := c.ExractS3PathPrefixes(s3Paths...)
+	if err != nil {
+		return false, err
+	}
+	return c.IsS3Prefix(prefixes...)
+}
+
+func (c *Client) IsS3PathDir(s3Paths ...string) (bool, error) {
+	dirPaths, err := c.ExractS3PathPrefixes(s3Paths...)
+	if err != nil {
+		return false, err
+	}
+	return c.IsS3Prefix(dirPaths...)
+}
+
+// ClassifyS3Path reports whether the path is a directory (a prefix of other objects) or a file (directories take precedence)
+func (c *Client) ClassifyS3Path(s3Path string) (S3PathKind, error) {
+	keys, err := c.ExractS3PathPrefixes(s3Path)
+	if err != nil {
+		return NotFoundS3PathKind, err
+	}
+	key := keys[0]
+
+	dirPrefix := key
+	if dirPrefix != "" && !strings.HasSuffix(dirPrefix, "/") {
+		dirPrefix += "/"
+	}
+	output, err := c.listObjectsV2Page(aws.BackgroundContext(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String(c.Bucket),
+		Prefix:    aws.String(dirPrefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(1),
+	})
+	if err != nil {
+		return NotFoundS3PathKind, errors.Wrap(err, s3Path)
+	}
+	if len(output.Contents) > 0 || len(output.CommonPrefixes) > 0 {
+		return DirS3PathKind, nil
+	}
+	if key == dirPrefix {
+		return NotFoundS3PathKind, nil
+	}
+
+	_, err = c.GetS3ObjectMetadata(key)
+	if IsNotFoundErr(err) {
+		return NotFoundS3PathKind, nil
+	}
+	if err != nil {
+		return NotFoundS3PathKind, errors.Wrap(err, s3Path)
+	}
+	return FileS3PathKind, nil
+}
+
+const ContentHashMetadataKey = "content-sha256" // stored by S3 as x-amz-meta-content-sha256
+
+type UploadOptions struct {
+	ContentType             string
+	ContentEncoding         string
+	ContentDisposition      string // defaults to "attachment"; use "inline" for objects which browsers should render (e.g. JSON served from presigned URLs)
+	ContentLanguage         string
+	Expires                 *time.Time
+	WebsiteRedirectLocation string // only used by buckets configured for static website hosting
+	StoreContentHash        bool   // store the SHA256 of the body in the object's metadata (see GetS3ContentHash)
+	Metadata                map[string]string
+	ACL                     string // defaults to "private"
+	ServerSideEncryption    string // overrides the client's ServerSideEncryption for this upload
+
+	// Object lock retention; only valid on buckets which were created with object lock enabled
+	ObjectLockMode            string // s3.ObjectLockModeGovernance or s3.ObjectLockModeCompliance
+	ObjectLockRetainUntilDate *time.Time
+
+	// If the bucket doesn't exist, create it (see CreateS3Bucket) and retry the upload once
+	CreateBucketIfMissing bool
+
+	// Only used by UploadBytesesToS3WithOptions: if any upload fails, delete the keys which were
+	// written (best-effort), so that either all of the keys are written or none are
+	RollbackOnFailure bool
+
+	// Only used by UploadBytesesToS3WithOptions: return an *S3BatchError with every failed upload, rather than the first error
+	CollectAllErrors bool
+}
+
+// S3BatchError is returned by batch operations which report every failure rather than only the first
+type S3BatchError struct {
+	Errors map[string]error // key -> error
+}
+
+func (e *S3BatchError) Error() string {
+	keys := e.Keys()
+	msgs := make([]string, len(keys))
+	for i, key := range keys {
+		msgs[i] = fmt.Sprintf("%s: %s", key, e.Errors[key].Error())
+	}
+	return fmt.Sprintf("%d S3 operations failed: %s", len(keys), strings.Join(msgs, "; "))
+}
+
+// Keys returns the keys which failed, sorted
+func (e *S3BatchError) Keys() []string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runS3KeyBatch runs fn for each key in parallel, and returns an *S3BatchError with every failure (or nil)
+func runS3KeyBatch(keys []string, fn func(key string) error) error {
+	fns := make([]func() error, len(keys))
+	for i, key := range keys {
+		key := key
+		fns[i] = func() error {
+			return fn(key)
+		}
+	}
+
+	batchErr := &S3BatchError{Errors: map[string]error{}}
+	for i, err := range parallel.RunWithLimit(_s3BatchConcurrency, fns...) {
+		if err != nil {
+			batchErr.Errors[keys[i]] = err
+		}
+	}
+	if len(batchErr.Errors) == 0 {
+		return nil
+	}
+	return batchErr
+}
+
+func (c *Client) UploadBytesToS3(data []byte, key string) error {
+	return c.UploadBytesToS3WithOptions(data, key, UploadOptions{})
+}
+
+// sniffContentType detects the content type of data when the key's extension doesn't identify it (nil if unknown or not needed)
+func sniffContentType(key string, data []byte) *string {
+	if mime.TypeByExtension(path.Ext(key)) != "" {
+		return nil
+	}
+	if len(data) > 512 {
+		data = data[:512] // http.DetectContentType only considers the first 512 bytes
+	}
+	contentType := http.DetectContentType(data)
+	if contentType == "application/octet-stream" {
+		return nil
+	}
+	return aws.String(contentType)
+}
+
+func (c *Client) UploadBytesToS3WithOptions(data []byte, key string, options UploadOptions) error {
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Body:                 bytes.NewReader(data),
+		ContentLength:        aws.Int64(int64(len(data))),
+		Key:                  aws.String(key),
+		Bucket:               aws.String(c.Bucket),
+		ACL:                  aws.String("private"),
+		ContentDisposition:   aws.String("attachment"),
+		ServerSideEncryption: c.serverSideEncryption(),
+	}
+	if options.ContentType != "" {
+		input.ContentType = aws.String(options.ContentType)
+	} else if options.ContentEncoding == "" {
+		input.ContentType = sniffContentType(key, data)
+	}
+	if options.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(options.ContentEncoding)
+	}
+	if options.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(options.ContentDisposition)
+	}
+	if options.ContentLanguage != "" {
+		input.ContentLanguage = aws.String(options.ContentLanguage)
+	}
+	if options.Expires != nil {
+		input.Expires = options.Expires
+	}
+	if options.WebsiteRedirectLocation != "" {
+		input.WebsiteRedirectLocation = aws.String(options.WebsiteRedirectLocation)
+	}
+	if options.ACL != "" {
+		input.ACL = aws.String(options.ACL)
+	}
+	if options.ServerSideEncryption != "" {
+		input.ServerSideEncryption = serverSideEncryptionValue(options.ServerSideEncryption)
+	}
+	if len(options.Metadata) > 0 {
+		input.Metadata = aws.StringMap(options.Metadata)
+	}
+	if options.StoreContentHash {
+		if input.Metadata == nil {
+			input.Metadata = map[string]*string{}
+		}
+		input.Metadata[ContentHashMetadataKey] = aws.String(ContentHash(data))
+	}
+	if options.ObjectLockMode != "" || options.ObjectLockRetainUntilDate != nil {
+		if err := c.checkObjectLockRetention(options); err != nil {
+			return errors.Wrap(err, key)
+		}
+		input.ObjectLockMode = aws.String(options.ObjectLockMode)
+		input.ObjectLockRetainUntilDate = options.ObjectLockRetainUntilDate
+	}
+
+	putObject := func() error {
+		input.Body = bytes.NewReader(data)
+		ctx, cancel := c.s3Context()
+		defer cancel()
+		_, err := c.s3API().PutObjectWithContext(ctx, input, c.s3RequestOptions()...)
+		return err
+	}
+
+	err = putObject()
+	if options.CreateBucketIfMissing && IsNoSuchBucketErr(err) {
+		if err := c.CreateS3Bucket(); err != nil {
+			return errors.Wrap(err, key)
+		}
+		err = putObject()
+	}
+	return errors.Wrap(err, key)
+}
+
+// CreateS3Bucket creates the client's bucket in the client's region, with the client's server-side encryption as its default
+func (c *Client) CreateS3Bucket() error {
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(c.Bucket),
+	}
+	// us-east-1 is the default, and S3 rejects it as an explicit location constraint
+	if c.Region != "" && c.Region != endpoints.UsEast1RegionID {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(c.Region),
+		}
+	}
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := c.s3API().CreateBucketWithContext(ctx, input, c.s3RequestOptions()...)
+	if err != nil && !CheckErrCode(err, s3.ErrCodeBucketAlreadyOwnedByYou) {
+		return errors.Wrap(err, c.Bucket)
+	}
+
+	sseAlgorithm := serverSideEncryptionValue(c.ServerSideEncryption)
+	if sseAlgorithm == nil {
+		return nil
+	}
+	encryptionCtx, encryptionCancel := c.s3Context()
+	defer encryptionCancel()
+	_, err = c.s3API().PutBucketEncryptionWithContext(encryptionCtx, &s3.PutBucketEncryptionInput{
+		Bucket: aws.String(c.Bucket),
+		ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+			Rules: []*s3.ServerSideEncryptionRule{{
+				ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+					SSEAlgorithm: sseAlgorithm,
+				},
+			}},
+		},
+	}, c.s3RequestOptions()...)
+	return errors.Wrap(err, c.Bucket)
+}
+
+// UploadReaderWithLengthToS3 streams exactly length bytes from the reader with a single PutObject
+func (c *Client) UploadReaderWithLengthToS3(r io.Reader, length int64, key string) error {
+	if length < 0 {
+		return errors.New(key, fmt.Sprintf("invalid content length (%d)", length))
+	}
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		ContentLength:        aws.Int64(length),
+		Key:                  aws.String(key),
+		Bucket:               aws.String(c.Bucket),
+		ACL:                  aws.String("private"),
+		ContentDisposition:   aws.String("attachment"),
+		ServerSideEncryption: c.serverSideEncryption(),
+	}
+
+	if readerAt, ok := r.(readAtSeeker); ok {
+		offset, err := readerAt.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return errors.Wrap(err, key)
+		}
+		end, err := readerAt.Seek(0, io.SeekEnd)
+		if err != nil {
+			return errors.Wrap(err, key)
+		}
+		if end-offset < length {
+			return errors.New(key, fmt.Sprintf("unable to read %d bytes: reader has %d bytes", length, end-offset))
+		}
+
+		head := make([]byte, sniffLength(length))
+		if _, err := readerAt.ReadAt(head, offset); err != nil {
+			return errors.Wrap(err, key)
+		}
+		input.ContentType = sniffContentType(key, head)
+
+		input.Body = io.NewSectionReader(readerAt, offset, length)
+		ctx, cancel := c.s3Context()
+		defer cancel()
+		_, err = c.s3API().PutObjectWithContext(ctx, input, c.s3RequestOptions()...)
+		return errors.Wrap(err, key)
+	}
+
+	bufReader := bufio.NewReaderSize(r, 512)
+	head, _ := bufReader.Peek(int(sniffLength(length))) // a short read is reported by exactLengthReader
+	input.ContentType = sniffContentType(key, head)
+	input.Body = aws.ReadSeekCloser(&exactLengthReader{reader: bufReader, remaining: length})
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err = c.s3API().PutObjectWithContext(ctx, input, append(c.s3RequestOptions(), unseekableBodyOption)...)
+	return errors.Wrap(err, key)
+}
+
+// sniffLength is the number of bytes of a body of the given length which sniffContentType considers
+func sniffLength(length int64) int64 {
+	if length > 512 {
+		return 512
+	}
+	return length
+}
+
+type readAtSeeker interface {
+	io.ReaderAt
+	io.Seeker
+}
+
+// exactLengthReader reads up to remaining bytes, and returns io.ErrUnexpectedEOF if the reader ends before that
+type exactLengthReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *exactLengthReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		return n, io.ErrUnexpectedEOF
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// unseekableBodyOption sends the body unsigned, without retries or region redirects, since it can only be read once
+func unseekableBodyOption(r *request.Request) {
+	r.Handlers.Sign.Swap(v4.SignRequestHandler.Name, v4.BuildNamedHandler(v4.SignRequestHandler.Name, v4.WithUnsignedPayload))
+	r.Handlers.AfterRetry.RemoveByName(_regionRedirectHandlerName)
+	r.Retryer = client.DefaultRetryer{NumMaxRetries: 0}
+}
+
+func (c *Client) checkObjectLockRetention(options UploadOptions) error {
+	if options.ObjectLockMode != s3.ObjectLockModeGovernance && options.ObjectLockMode != s3.ObjectLockModeCompliance {
+		return ErrorInvalidObjectLockRetention(options.ObjectLockMode)
+	}
+	if options.ObjectLockRetainUntilDate == nil {
+		return ErrorInvalidObjectLockRetention(options.ObjectLockMode)
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().GetObjectLockConfigurationWithContext(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+	if CheckErrCode(err, "ObjectLockConfigurationNotFoundError") {
+		return ErrorObjectLockNotEnabled(c.Bucket)
+	}
+	if err != nil {
+		return err
+	}
+	if output.ObjectLockConfiguration == nil || aws.StringValue(output.ObjectLockConfiguration.ObjectLockEnabled) != s3.ObjectLockEnabledEnabled {
+		return ErrorObjectLockNotEnabled(c.Bucket)
+	}
+
+	return nil
+}
+
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GetS3ContentHash returns the hash stored by an upload with StoreContentHash, or "" if the object has none
+func (c *Client) GetS3ContentHash(key string) (string, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(key),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return "", errors.Wrap(err, key)
+	}
+
+	return metadataValue(output.Metadata, ContentHashMetadataKey), nil
+}
+
+type S3ObjectMetadata struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+	ContentType  string
+	Metadata     map[string]*string
+
+	WebsiteRedirectLocation string // only set by ReadBytesAndMetadataFromS3
+}
+
+func (c *Client) GetS3ObjectMetadata(key string) (*S3ObjectMetadata, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(key),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	return headObjectMetadata(key, output), nil
+}
+
+// EstimateDownloadBytes returns the total size of the objects (from parallel HeadObject calls, without downloading them)
+func (c *Client) EstimateDownloadBytes(keys ...string) (int64, error) {
+	sizes := make([]int64, len(keys))
+	fns := make([]func() error, len(keys))
+	for i := range keys {
+		i := i
+		fns[i] = func() error {
+			metadata, err := c.GetS3ObjectMetadata(keys[i])
+			if err != nil {
+				return err
+			}
+			sizes[i] = metadata.Size
+			return nil
+		}
+	}
+	if err := parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...); err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return total, nil
+}
+
+// HeadObjectIfModifiedSince returns the object's metadata if it was modified after since (and changed=false otherwise)
+func (c *Client) HeadObjectIfModifiedSince(key string, since time.Time) (bool, *S3ObjectMetadata, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket:          aws.String(c.Bucket),
+		Key:             aws.String(key),
+		IfModifiedSince: aws.Time(since),
+	}, c.s3RequestOptions()...)
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotModified {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, errors.Wrap(err, key)
+	}
+	return true, headObjectMetadata(key, output), nil
+}
+
+func headObjectMetadata(key string, output *s3.HeadObjectOutput) *S3ObjectMetadata {
+	return &S3ObjectMetadata{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		LastModified: aws.TimeValue(output.LastModified),
+		ETag:         aws.StringValue(output.ETag),
+		ContentType:  aws.StringValue(output.ContentType),
+		Metadata:     output.Metadata,
+	}
+}
+
+// GetS3ObjectsMetadata fetches the metadata of the keys in parallel; keys which don't exist are omitted from the result
+func (c *Client) GetS3ObjectsMetadata(keys ...string) (map[string]*S3ObjectMetadata, error) {
+	metadatas := make(map[string]*S3ObjectMetadata, len(keys))
+	var mux sync.Mutex
+
+	fns := make([]func() error, len(keys))
+	for i, key := range keys {
+		key := key
+		fns[i] = func() error {
+			metadata, err := c.GetS3ObjectMetadata(key)
+			if IsNotFoundErr(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			metadatas[key] = metadata
+			mux.Unlock()
+			return nil
+		}
+	}
+
+	if err := parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...); err != nil {
+		return nil, err
+	}
+	return metadatas, nil
+}
+
+// UploadBytesToS3IfDifferent uploads the object unless it exists with the same content and metadata, and returns whether it did
+func (c *Client) UploadBytesToS3IfDifferent(data []byte, key string, metadata map[string]string) (bool, error) {
+	existing, err := c.GetS3ObjectMetadata(key)
+	if err != nil && !IsNotFoundErr(err) {
+		return false, err
+	}
+
+	if existing != nil && metadataValue(existing.Metadata, ContentHashMetadataKey) == ContentHash(data) && userMetadataEqual(existing.Metadata, metadata) {
+		return false, nil
+	}
+
+	err = c.UploadBytesToS3WithOptions(data, key, UploadOptions{
+		StoreContentHash: true,
+		Metadata:         metadata,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// userMetadataEqual compares an object's metadata (except its content hash) to metadata, with case-insensitive keys
+func userMetadataEqual(objectMetadata map[string]*string, metadata map[string]string) bool {
+	numObjectKeys := 0
+	for k := range objectMetadata {
+		if !strings.EqualFold(k, ContentHashMetadataKey) {
+			numObjectKeys++
+		}
+	}
+	if numObjectKeys != len(metadata) {
+		return false
+	}
+
+	for k, v := range metadata {
+		if !hasMetadataKey(objectMetadata, k) || metadataValue(objectMetadata, k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// S3 returns metadata keys in canonical header form (e.g. "Content-Sha256"), so keys are compared case-insensitively
+func hasMetadataKey(metadata map[string]*string, key string) bool {
+	for k := range metadata {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func metadataValue(metadata map[string]*string, key string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return aws.StringValue(v)
+		}
+	}
+	return ""
+}
+
+func (c *Client) UploadBytesesToS3(data []byte, keys ...string) error {
+	return c.UploadBytesesToS3WithOptions(data, keys, UploadOptions{})
+}
+
+func (c *Client) UploadBytesesToS3WithOptions(data []byte, keys []string, options UploadOptions) error {
+	var written []string
+	var mux sync.Mutex
+
+	upload := func(key string) error {
+		if err := c.UploadBytesToS3WithOptions(data, key, options); err != nil {
+			return err
+		}
+		mux.Lock()
+		written = append(written, key)
+		mux.Unlock()
+		return nil
+	}
+
+	var err error
+	if options.CollectAllErrors {
+		err = runS3KeyBatch(keys, upload)
+	} else {
+		fns := make([]func() error, len(keys))
+		for i, key := range keys {
+			key := key
+			fns[i] = func() error {
+				return upload(key)
+			}
+		}
+		err = parallel.RunFirstErr(fns...)
+	}
+	if err != nil && options.RollbackOnFailure && len(written) > 0 {
+		c.DeleteS3Keys(written) // best-effort; the upload error is more useful to the caller
+	}
+	return err
+}
+
+func (c *Client) UploadGzipBytesToS3(data []byte, key string) error {
+	return c.UploadGzipBytesToS3WithLevel(data, key, gzip.DefaultCompression)
+}
+
+// UploadGzipBytesToS3WithLevel gzips the data with the compression level, and uploads it with Content-Encoding: gzip
+func (c *Client) UploadGzipBytesToS3WithLevel(data []byte, key string, level int) error {
+	if level != gzip.DefaultCompression && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+		return ErrorInvalidGzipCompressionLevel(level)
+	}
+
+	var buf bytes.Buffer
+	gzipWriter, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return errors.Wrap(err, key)
+	}
+	if _, err := gzipWriter.Write(data); err != nil {
+		return errors.Wrap(err, key)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return errors.Wrap(err, key)
+	}
+
+	return c.UploadBytesToS3WithOptions(buf.Bytes(), key, UploadOptions{ContentEncoding: "gzip"})
+}
+
+func (c *Client) UploadFileToS3(filePath string, key string) error {
+	data, err := files.ReadFileBytes(filePath)
+	if err != nil {
+		return err
+	}
+	return c.UploadBytesToS3(data, key)
+}
+
+// UploadFileToS3UnderPrefix uploads the file to s3Prefix joined with the file's path relative to localRoot
+func (c *Client) UploadFileToS3UnderPrefix(filePath string, localRoot string, s3Prefix string) error {
+	relPath, err := filepath.Rel(localRoot, filePath)
+	if err != nil {
+		return errors.Wrap(err, filePath)
+	}
+	if relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return errors.New(filePath, fmt.Sprintf("is not in %s", localRoot))
+	}
+	return c.UploadFileToS3(filePath, S3KeyJoin(s3Prefix, filepath.ToSlash(relPath)))
+}
+
+// SyncDirToS3 uploads every file under localDir to s3Prefix (preserving relative paths), applying options to each upload
+func (c *Client) SyncDirToS3(localDir string, s3Prefix string, options UploadOptions) error {
+	if err := files.CheckDir(localDir); err != nil {
+		return err
+	}
+	relPaths, err := files.ListDirRecursive(localDir, true)
+	if err != nil {
+		return err
+	}
+
+	fns := make([]func() error, len(relPaths))
+	for i, relPath := range relPaths {
+		relPath := relPath
+		fns[i] = func() error {
+			data, err := files.ReadFileBytes(filepath.Join(localDir, relPath))
+			if err != nil {
+				return err
+			}
+			return c.UploadBytesToS3WithOptions(data, S3KeyJoin(s3Prefix, filepath.ToSlash(relPath)), options)
+		}
+	}
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
+}
+
+// TransformS3Object reads srcKey, applies transform, and uploads the result to destKey (which may be srcKey)
+func (c *Client) TransformS3Object(srcKey string, destKey string, transform func([]byte) ([]byte, error)) error {
+	data, metadata, err := c.ReadBytesAndMetadataFromS3(srcKey)
+	if err != nil {
+		return err
+	}
+
+	transformed, err := transform(data)
+	if err != nil {
+		return errors.Wrap(err, srcKey)
+	}
+
+	return c.UploadBytesToS3WithOptions(transformed, destKey, UploadOptions{ContentType: metadata.ContentType})
+}
+
+// UploadBytesToS3Verified uploads the object, reads it back, and returns ErrorS3IntegrityCheckFailed if the content differs
+func (c *Client) UploadBytesToS3Verified(data []byte, key string) error {
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return err
+	}
+	if err := c.UploadBytesToS3(data, key); err != nil {
+		return err
+	}
+
+	readData, err := c.ReadBytesFromS3(key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, readData) {
+		return ErrorS3IntegrityCheckFailed(key, len(data), len(readData))
+	}
+	return nil
+}
+
+// UploadBytesToS3WithBackup copies the existing object (if any) to backupKey, and then uploads data to key
+func (c *Client) UploadBytesToS3WithBackup(data []byte, key string, backupKey string) error {
+	err := c.CopyS3ToS3(key, backupKey)
+	if err != nil && !IsNoSuchKeyErr(err) {
+		return err
+	}
+	return c.UploadBytesToS3(data, key)
+}
+
+func (c *Client) UploadBufferToS3(buffer *bytes.Buffer, key string) error {
+	return c.UploadBytesToS3(buffer.Bytes(), key)
+}
+
+func (c *Client) UploadStringToS3(str string, key string) error {
+	str = strings.TrimSpace(str)
+	return c.UploadBytesToS3([]byte(str), key)
+}
+
+func (c *Client) UploadJSONToS3(obj interface{}, key string) error {
+	return c.WriteObject(obj, key, JSONCodec)
+}
+
+func (c *Client) ReadJSONFromS3(objPtr interface{}, key string) error {
+	return c.ReadObject(objPtr, key, JSONCodec)
+}
+
+// ReadJSONRawFromS3 reads a JSON object without decoding its fields, so they can be decoded selectively
+func (c *Client) ReadJSONRawFromS3(key string) (map[string]json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := c.ReadJSONFromS3(&fields, key); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// ReadValidatedJSONFromS3 reads a JSON object into objPtr and then runs validate on it (e.g. to check required fields)
+func (c *Client) ReadValidatedJSONFromS3(objPtr interface{}, key string, validate func(interface{}) error) error {
+	if err := c.ReadJSONFromS3(objPtr, key); err != nil {
+		return err
+	}
+	if err := validate(objPtr); err != nil {
+		return errors.Wrap(err, c.S3Path(key))
+	}
+	return nil
+}
+
+// UploadJSONLinesToS3 uploads the records as newline-delimited JSON, encoding them as the upload streams
+func (c *Client) UploadJSONLinesToS3(records []interface{}, key string) error {
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+
+	go func() {
+		encoder := json.NewEncoder(writer)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.Close()
+	}()
+
+	_, err = c.s3Uploader().Upload(&s3manager.UploadInput{
+		Body:                 reader,
+		Key:                  aws.String(key),
+		Bucket:               aws.String(c.Bucket),
+		ACL:                  aws.String("private"),
+		ContentDisposition:   aws.String("attachment"),
+		ContentType:          aws.String("application/x-ndjson"),
+		ServerSideEncryption: c.serverSideEncryption(),
+	})
+	return errors.Wrap(err, key)
+}
+
+type s3Writer struct {
+	key       string
+	writer    *io.PipeWriter
+	done      chan error
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewS3Writer returns a writer which streams to the key; the upload is finalized by Close, which returns any upload error
+func (c *Client) NewS3Writer(key string) io.WriteCloser {
+	reader, writer := io.Pipe()
+	w := &s3Writer{
+		key:    key,
+		writer: writer,
+		done:   make(chan error, 1),
+	}
+
+	key, err := c.uploadKey(key)
+	if err != nil {
+		reader.CloseWithError(err)
+		w.done <- err
+		return w
+	}
+
+	go func() {
+		_, err := c.s3Uploader().Upload(&s3manager.UploadInput{
+			Body:                 reader,
+			Key:                  aws.String(key),
+			Bucket:               aws.String(c.Bucket),
+			ACL:                  aws.String("private"),
+			ContentDisposition:   aws.String("attachment"),
+			ServerSideEncryption: c.serverSideEncryption(),
+		})
+		// unblock any pending or future writes if the upload stopped reading
+		if err != nil {
+			reader.CloseWithError(err)
+		} else {
+			reader.Close()
+		}
+		w.done <- err
+	}()
+
+	return w
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, errors.Wrap(err, w.key)
+	}
+	return n, nil
+}
+
+func (w *s3Writer) Close() error {
+	w.closeOnce.Do(func() {
+		w.writer.Close()
+		w.closeErr = errors.Wrap(<-w.done, w.key)
+	})
+	return w.closeErr
+}
+
+// ReadJSONLinesFromS3 streams a newline-delimited JSON object, calling fn with each non-empty line
+func (c *Client) ReadJSONLinesFromS3(key string, fn func(line []byte) error) error {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	reader := bufio.NewReader(response.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if fnErr := fn(trimmed); fnErr != nil {
+				return errors.Wrap(fnErr, key)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, key)
+		}
+	}
+}
+
+func (c *Client) UploadMsgpackToS3(obj interface{}, key string) error {
+	return c.WriteObject(obj, key, MsgpackCodec)
+}
+
+func (c *Client) ReadMsgpackFromS3(objPtr interface{}, key string) error {
+	return c.ReadObject(objPtr, key, MsgpackCodec)
+}
+
+// S3MsgpackStreamWriter uploads a stream of msgpack-encoded records as they are appended
+type S3MsgpackStreamWriter struct {
+	writer    io.WriteCloser
+	bufWriter *bufio.Writer
+	encoder   *codec.Encoder
+}
+
+func (c *Client) NewS3MsgpackStreamWriter(key string) *S3MsgpackStreamWriter {
+	writer := c.NewS3Writer(key)
+	bufWriter := bufio.NewWriter(writer)
+	return &S3MsgpackStreamWriter{
+		writer:    writer,
+		bufWriter: bufWriter,
+		encoder:   msgpack.NewEncoder(bufWriter),
+	}
+}
+
+func (w *S3MsgpackStreamWriter) Append(record interface{}) error {
+	return errors.WithStack(w.encoder.Encode(record))
+}
+
+// Close finalizes the upload, and returns any upload error
+func (w *S3MsgpackStreamWriter) Close() error {
+	if err := w.bufWriter.Flush(); err != nil {
+		w.writer.Close()
+		return errors.WithStack(err)
+	}
+	return w.writer.Close()
+}
+
+// ReadMsgpackStreamFromS3 streams an object written by S3MsgpackStreamWriter, calling fn with each record's msgpack encoding
+func (c *Client) ReadMsgpackStreamFromS3(key string, fn func(record []byte) error) error {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	decoder := msgpack.NewDecoder(bufio.NewReader(response.Body))
+	for {
+		var record codec.Raw
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, key)
+		}
+		if err := fn(record); err != nil {
+			return errors.Wrap(err, key)
+		}
+	}
+}
+
+func (c *Client) ReadStringFromS3(key string) (string, error) {
+	data, err := c.ReadBytesFromS3(key)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ReadBytesFromS3 returns an empty (non-nil) slice for an empty object, and an error if the object doesn't exist
+func (c *Client) ReadBytesFromS3(key string) ([]byte, error) {
+	data, _, err := c.ReadBytesAndMetadataFromS3(key)
+	return data, err
+}
+
+// ReadIntoBuffer resets buf and reads the object into it, so that buffers can be reused across reads (e.g. with a sync.Pool)
+func (c *Client) ReadIntoBuffer(key string, buf *bytes.Buffer) error {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	buf.Reset()
+	if _, err := buf.ReadFrom(response.Body); err != nil {
+		return errors.Wrap(err, key)
+	}
+	return nil
+}
+
+// ReadRequiredBytesFromS3 is like ReadBytesFromS3, but returns ErrorS3ObjectEmpty if the object is empty
+func (c *Client) ReadRequiredBytesFromS3(key string) ([]byte, error) {
+	data, err := c.ReadBytesFromS3(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrorS3ObjectEmpty(key)
+	}
+	return data, nil
+}
+
+// ReadBytesAndMetadataFromS3 returns the object's content and metadata from a single GetObject
+func (c *Client) ReadBytesAndMetadataFromS3(key string) ([]byte, *S3ObjectMetadata, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+
+	if err != nil {
+		return nil, nil, errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	buf := bytes.NewBuffer([]byte{}) // empty objects are read as an empty, non-nil slice
+	if _, err := buf.ReadFrom(response.Body); err != nil {
+		return nil, nil, errors.Wrap(err, key)
+	}
+
+	return buf.Bytes(), &S3ObjectMetadata{
+		Key:          key,
+		Size:         int64(buf.Len()),
+		LastModified: aws.TimeValue(response.LastModified),
+		ETag:         aws.StringValue(response.ETag),
+		ContentType:  aws.StringValue(response.ContentType),
+		Metadata:     response.Metadata,
+
+		WebsiteRedirectLocation: aws.StringValue(response.WebsiteRedirectLocation),
+	}, nil
+}
+
+var _s3MaxRedirects = 10
+
+// ReadBytesFollowingRedirect reads the object, following website redirects to other keys in the bucket
+func (c *Client) ReadBytesFollowingRedirect(key string) ([]byte, error) {
+	visited := []string{key}
+	for {
+		data, metadata, err := c.ReadBytesAndMetadataFromS3(key)
+		if err != nil {
+			return nil, err
+		}
+
+		location := metadata.WebsiteRedirectLocation
+		if location == "" {
+			return data, nil
+		}
+		if !strings.HasPrefix(location, "/") {
+			return nil, ErrorS3RedirectNotFollowed(key, location, "it redirects outside of the bucket")
+		}
+
+		key = strings.TrimPrefix(location, "/")
+		if slices.HasString(visited, key) {
+			return nil, ErrorS3RedirectNotFollowed(visited[0], location, "it is part of a redirect loop")
+		}
+		if len(visited) > _s3MaxRedirects {
+			return nil, ErrorS3RedirectNotFollowed(visited[0], location, fmt.Sprintf("there are more than %d redirects", _s3MaxRedirects))
+		}
+		visited = append(visited, key)
+	}
+}
+
+// ReadBytesFromS3Limited reads the object, and returns ErrorS3ObjectTooLarge without buffering it if it is larger than maxBytes
+func (c *Client) ReadBytesFromS3Limited(key string, maxBytes int64) ([]byte, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	if aws.Int64Value(response.ContentLength) > maxBytes {
+		return nil, ErrorS3ObjectTooLarge(key, maxBytes)
+	}
+
+	// read one extra byte in case the content length is missing or wrong
+	buf := bytes.NewBuffer([]byte{})
+	if _, err := buf.ReadFrom(io.LimitReader(response.Body, maxBytes+1)); err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	if int64(buf.Len()) > maxBytes {
+		return nil, ErrorS3ObjectTooLarge(key, maxBytes)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ReadBytesFromS3Decoded reads the object, and gunzips it if it was stored with Content-Encoding: gzip
+func (c *Client) ReadBytesFromS3Decoded(key string) ([]byte, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	var body io.Reader = response.Body
+	if strings.EqualFold(strings.TrimSpace(aws.StringValue(response.ContentEncoding)), "gzip") {
+		gzipReader, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	return data, nil
+}
+
+// ReadZstdFromS3 reads a zstd-compressed object (e.g. a .zst file) and returns its decompressed content
+func (c *Client) ReadZstdFromS3(key string) ([]byte, error) {
+	data, err := c.ReadBytesFromS3(key)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := ioutil.ReadAll(zstd.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	return decompressed, nil
+}
+
+// ResumableReadBytesFromS3 reads the object, resuming with a ranged GET (pinned to its ETag) if the body fails mid-stream
+func (c *Client) ResumableReadBytesFromS3(key string) ([]byte, error) {
+	input := &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	var size int64
+	for resumes := 0; ; resumes++ {
+		ctx, cancel := c.s3Context()
+		response, err := c.s3API().GetObjectWithContext(ctx, input, c.s3RequestOptions()...)
+		if err != nil {
+			cancel()
+			return nil, errors.Wrap(err, key)
+		}
+		if input.Range == nil {
+			size = aws.Int64Value(response.ContentLength)
+			input.IfMatch = response.ETag
+		}
+
+		_, err = buf.ReadFrom(response.Body)
+		response.Body.Close()
+		cancel()
+		if err == nil && int64(buf.Len()) < size {
+			err = io.ErrUnexpectedEOF
+		}
+		if err == nil {
+			return buf.Bytes(), nil
+		}
+		if resumes == _resumableReadMaxResumes {
+			return nil, errors.Wrap(err, key)
+		}
+
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", buf.Len()))
+	}
+}
+
+type s3ReadSeeker struct {
+	client *Client
+	key    string
+	etag   string
+	size   int64
+	offset int64
+}
+
+// NewS3ReadSeeker returns an io.ReadSeeker over the object (and its size) which fetches each Read with a ranged GET
+func (c *Client) NewS3ReadSeeker(key string) (io.ReadSeeker, int64, error) {
+	metadata, err := c.GetS3ObjectMetadata(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &s3ReadSeeker{
+		client: c,
+		key:    key,
+		etag:   metadata.ETag,
+		size:   metadata.Size,
+	}, metadata.Size, nil
+}
+
+func (rs *s3ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	end := rs.offset + int64(len(p)) - 1
+	if end > rs.size-1 {
+		end = rs.size - 1
+	}
+
+	ctx, cancel := rs.client.s3Context()
+	defer cancel()
+	response, err := rs.client.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:     aws.String(rs.key),
+		Bucket:  aws.String(rs.client.Bucket),
+		Range:   aws.String(fmt.Sprintf("bytes=%d-%d", rs.offset, end)),
+		IfMatch: aws.String(rs.etag),
+	}, rs.client.s3RequestOptions()...)
+	if err != nil {
+		return 0, errors.Wrap(err, rs.key)
+	}
+	defer response.Body.Close()
+
+	n, err := io.ReadFull(response.Body, p[:end-rs.offset+1])
+	rs.offset += int64(n)
+	if err != nil {
+		return n, errors.Wrap(err, rs.key)
+	}
+	return n, nil
+}
+
+func (rs *s3ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var newOffset int64
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekCurrent:
+		newOffset = rs.offset + offset
+	case io.SeekEnd:
+		newOffset = rs.size + offset
+	default:
+		return 0, errors.New(rs.key, fmt.Sprintf("invalid whence (%d)", whence))
+	}
+	if newOffset < 0 {
+		return 0, errors.New(rs.key, fmt.Sprintf("negative position (%d)", newOffset))
+	}
+	rs.offset = newOffset
+	return newOffset, nil
+}
+
+// ReadS3ObjectHead returns the first n bytes of the object (or the whole object if it is smaller than n)
+func (c *Client) ReadS3ObjectHead(key string, n int64) ([]byte, error) {
+	if n <= 0 {
+		return []byte{}, nil
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+		Range:  aws.String(fmt.Sprintf("bytes=0-%d", n-1)),
+	}, c.s3RequestOptions()...)
+	if CheckErrCode(err, "InvalidRange") {
+		// S3 can't satisfy any range of an empty object
+		return []byte{}, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, n))
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	return data, nil
+}
+
+// ReadGzipRangeFromS3 gunzips bytes start through end (inclusive) of the object, which must contain complete gzip members
+func (c *Client) ReadGzipRangeFromS3(key string, start int64, end int64) ([]byte, error) {
+	if start < 0 || end < start {
+		return nil, errors.New(key, fmt.Sprintf("invalid byte range (%d-%d)", start, end))
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+
+	response, err := c.s3API().GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return nil, errors.Wrap(err, key)
+	}
+	defer response.Body.Close()
+
+	gzipReader, err := gzip.NewReader(response.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, key, fmt.Sprintf("bytes %d-%d", start, end))
+	}
+	defer gzipReader.Close()
+
+	data, err := ioutil.ReadAll(gzipReader)
+	if err != nil {
+		return nil, errors.Wrap(err, key, fmt.Sprintf("bytes %d-%d", start, end))
+	}
+	return data, nil
+}
+
+// DownloadLargeToWriterAt downloads the object with concurrent ranged GETs, and returns the number of bytes written
+func (c *Client) DownloadLargeToWriterAt(key string, w io.WriterAt) (int64, error) {
+	downloader := c.s3Downloader(func(d *s3manager.Downloader) {
+		if c.S3PartSize <= 0 {
+			d.PartSize = _largeDownloadPartSize
+		}
+		if c.S3Concurrency <= 0 {
+			d.Concurrency = _largeDownloadConcurrency
+		}
+	})
+
+	numBytes, err := downloader.Download(w, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, key)
+	}
+	return numBytes, nil
+}
+
+// DownloadMatchingFromS3 downloads the objects under prefix which match predicate to destDir, at their paths relative to prefix
+func (c *Client) DownloadMatchingFromS3(prefix string, predicate func(*s3.Object) bool, destDir string) error {
+	objects, err := c.listS3Objects(prefix)
+	if err != nil {
+		return err
+	}
+
+	var fns []func() error
+	for _, object := range objects {
+		if isS3DirMarker(*object.Key, aws.Int64Value(object.Size)) || !predicate(object) {
+			continue
+		}
+
+		key := *object.Key
+		localPath := filepath.Join(destDir, filepath.FromSlash(strings.TrimPrefix(key, prefix)))
+		if relPath, err := filepath.Rel(destDir, localPath); err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+			return errors.New(key, fmt.Sprintf("cannot be downloaded outside of %s", destDir))
+		}
+
+		fns = append(fns, func() error {
+			return c.downloadToFile(key, localPath)
+		})
+	}
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
+}
+
+func (c *Client) downloadToFile(key string, localPath string) error {
+	if err := files.MkdirAll(filepath.Dir(localPath)); err != nil {
+		return err
+	}
+	file, err := files.CreateFile(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = c.s3Downloader().Download(file, &s3.GetObjectInput{
+		Key:    aws.String(key),
+		Bucket: aws.String(c.Bucket),
+	})
+	return errors.Wrap(err, key)
+}
+
+// ListPrefix returns up to maxResults objects under prefix, following continuation tokens since S3 returns at most
+// 1000 objects per page
+func (c *Client) ListPrefix(prefix string, maxResults int64) ([]*s3.Object, error) {
+	listObjectsInput := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(maxResults),
+	}
+
+	var objects []*s3.Object
+	err := c.listObjectsV2Pages(listObjectsInput, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		objects = append(objects, output.Contents...)
+		return int64(len(objects)) < maxResults
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, prefix)
+	}
+
+	if int64(len(objects)) > maxResults {
+		objects = objects[:maxResults]
+	}
+	return objects, nil
+}
+
+// ListPrefixWithDeadline lists the objects under prefix, returning those gathered so far (and complete=false) after deadline
+func (c *Client) ListPrefixWithDeadline(prefix string, deadline time.Duration) ([]*s3.Object, bool, error) {
+	ctx, cancel := context.WithTimeout(aws.BackgroundContext(), deadline)
+	defer cancel()
+
+	listObjectsInput := &s3.ListObjectsV2Input{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var objects []*s3.Object
+	complete := false
+	err := c.listObjectsV2PagesWithContext(ctx, listObjectsInput, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		objects = append(objects, output.Contents...)
+		complete = lastPage
+		return ctx.Err() == nil
+	})
+	if err != nil && ctx.Err() == nil {
+		return nil, false, errors.Wrap(err, prefix)
+	}
+
+	return objects, complete, nil
+}
+
+// ListPrefixKeys returns up to maxResults keys under prefix (see ListPrefix)
+func (c *Client) ListPrefixKeys(prefix string, maxResults int64) ([]string, error) {
+	objects, err := c.ListPrefix(prefix, maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(objects))
+	for i, object := range objects {
+		keys[i] = *object.Key
+	}
+	return keys, nil
+}
+
+// ListS3Level returns the objects and "subdirectories" (common prefixes) immediately under the prefix
+func (c *Client) ListS3Level(prefix string) ([]*s3.Object, []string, error) {
+	var files []*s3.Object
+	var dirs []string
+
+	err := c.listObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(c.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		files = append(files, output.Contents...)
+		for _, commonPrefix := range output.CommonPrefixes {
+			dirs = append(dirs, *commonPrefix.Prefix)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, prefix)
+	}
+
+	return files, dirs, nil
+}
+
+// GroupS3ObjectsByDir groups the objects under prefix by their first path segment after it ("" for objects directly under it)
+func (c *Client) GroupS3ObjectsByDir(prefix string) (map[string][]*s3.Object, error) {
+	objects, err := c.listS3Objects(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := map[string][]*s3.Object{}
+	for _, object := range objects {
+		relKey := strings.TrimPrefix(strings.TrimPrefix(*object.Key, prefix), "/")
+		dir := ""
+		if i := strings.Index(relKey, "/"); i >= 0 {
+			dir = relKey[:i]
+		}
+		groups[dir] = append(groups[dir], object)
+	}
+	return groups, nil
+}
+
+// listObjectsV2Page lists a single page, retrying with backoff if S3 throttles the request
+func (c *Client) listObjectsV2Page(parentCtx aws.Context, input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	delay := _s3ThrottleInitialDelay
+	for retries := 0; ; retries++ {
+		ctx, cancel := c.s3ContextWithParent(parentCtx)
+		output, err := c.s3API().ListObjectsV2WithContext(ctx, input, c.s3RequestOptions()...)
+		cancel()
+		if err == nil || !IsSlowDownErr(err) || retries == _s3ThrottleMaxRetries {
+			return output, err
+		}
+
+		select {
+		case <-parentCtx.Done():
+			return nil, parentCtx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > _s3ThrottleMaxDelay {
+			delay = _s3ThrottleMaxDelay
+		}
+	}
+}
+
+// listObjectsV2Pages is like S3.ListObjectsV2Pages, but each page request gets its own context
+func (c *Client) listObjectsV2Pages(input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	return c.listObjectsV2PagesWithContext(aws.BackgroundContext(), input, fn)
+}
+
+func (c *Client) listObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool) error {
+	pageInput := *input
+
+	for {
+		output, err := c.listObjectsV2Page(ctx, &pageInput)
+		if err != nil {
+			return err
+		}
+
+		lastPage := !aws.BoolValue(output.IsTruncated) || output.NextContinuationToken == nil
+		if !fn(output, lastPage) || lastPage {
+			return nil
+		}
+
+		pageInput.ContinuationToken = output.NextContinuationToken
+	}
+}
+
+// DeleteFromS3ByPrefix deletes every object under prefix (an empty prefix requires allowDeleteAll)
+func (c *Client) DeleteFromS3ByPrefix(prefix string, continueIfFailure bool, allowDeleteAll bool) error {
+	return c.DeleteFromS3ByPrefixWithContext(aws.BackgroundContext(), prefix, continueIfFailure, allowDeleteAll)
+}
+
+// DeleteFromS3ByPrefixWithContext is like DeleteFromS3ByPrefix, but stops (returning ctx.Err()) if ctx is cancelled
+func (c *Client) DeleteFromS3ByPrefixWithContext(parentCtx aws.Context, prefix string, continueIfFailure bool, allowDeleteAll bool) error {
+	if strings.TrimSpace(prefix) == "" && !allowDeleteAll {
+		return ErrorS3DeleteAllNotAllowed(c.Bucket)
+	}
+
+	listObjectsInput := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1000),
+	}
+
+	var subErr error
+
+	err := c.listObjectsV2PagesWithContext(parentCtx, listObjectsInput,
+		func(listObjectsOutput *s3.ListObjectsV2Output, lastPage bool) bool {
+			if parentCtx.Err() != nil {
+				return false
+			}
+			deleteObjects := make([]*s3.ObjectIdentifier, len(listObjectsOutput.Contents))
+			for i, object := range listObjectsOutput.Contents {
+				deleteObjects[i] = &s3.ObjectIdentifier{Key: object.Key}
+			}
+			deleteObjectsInput := &s3.DeleteObjectsInput{
+				Bucket: aws.String(c.Bucket),
+				Delete: &s3.Delete{
+					Objects: deleteObjects,
+					Quiet:   aws.Bool(true),
+				},
+			}
+			ctx, cancel := c.s3ContextWithParent(parentCtx)
+			_, newSubErr := c.s3API().DeleteObjectsWithContext(ctx, deleteObjectsInput, c.s3RequestOptions()...)
+			cancel()
+			if newSubErr != nil {
+				subErr = newSubErr
+				if !continueIfFailure {
+					return false
+				}
+			}
+			return true
+		})
+
+	if parentCtx.Err() != nil {
+		return parentCtx.Err()
+	}
+	if subErr != nil {
+		return errors.Wrap(subErr, prefix)
+	}
+	return errors.Wrap(err, prefix)
+}
+
+func (c *Client) DeleteS3PathByPrefix(s3Path string, continueIfFailure bool) error {
+	prefixes, err := c.ExractS3PathPrefixes(s3Path)
+	if err != nil {
+		return err
+	}
+	return c.DeleteFromS3ByPrefix(prefixes[0], continueIfFailure, false)
+}
+
+// DeleteS3FileIfETagMatches deletes the object if its ETag matches, and returns ErrorPreconditionFailed otherwise
+func (c *Client) DeleteS3FileIfETagMatches(key string, etag string) error {
+	metadata, err := c.GetS3ObjectMetadata(key)
+	if err != nil {
+		return err
+	}
+	if strings.Trim(metadata.ETag, `"`) != strings.Trim(etag, `"`) {
+		return ErrorPreconditionFailed(key, etag)
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err = c.s3API().DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(key),
+	}, c.s3RequestOptions()...)
+	return errors.Wrap(err, key)
+}
+
+// MoveS3File copies srcKey to destKey and deletes srcKey, returning ErrorAlreadyExists if destKey exists and overwrite is false
+func (c *Client) MoveS3File(srcKey string, destKey string, overwrite bool) error {
+	destKey, err := c.uploadKey(destKey)
+	if err != nil {
+		return err
+	}
+
+	if !overwrite {
+		exists, err := c.IsS3File(destKey)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrorAlreadyExists(destKey)
+		}
+	}
+
+	if err := c.CopyS3ToS3(srcKey, destKey); err != nil {
+		return err
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err = c.s3API().DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(srcKey),
+	}, c.s3RequestOptions()...)
+	return errors.Wrap(err, srcKey)
+}
+
+// copySource returns the URL-encoded CopySource for a key in the client's bucket (or access point)
+func (c *Client) copySource(key string) string {
+	if IsS3AccessPointARN(c.Bucket) {
+		// S3 expects access point copy sources in the form <access point ARN>/object/<key>
+		accessPointARN, _ := parseS3AccessPointARN(c.Bucket) // validated when the client was created
+		name := strings.TrimPrefix(strings.TrimPrefix(accessPointARN.Resource, "accesspoint/"), "accesspoint:")
+		accessPointARN.Resource = "accesspoint/" + name
+
+		segments := strings.Split(key, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return accessPointARN.String() + "/object/" + strings.Join(segments, "/")
+	}
+	return url.PathEscape(c.Bucket + "/" + key)
+}
+
+type CopyOptions struct {
+	ACL               string            // defaults to private
+	Metadata          map[string]string // if set, replaces the source object's metadata (its content type is kept)
+	ContentType       string            // if set, replaces the source object's content type (its metadata is kept unless Metadata is set)
+	StorageClass      string            // defaults to the bucket's default storage class
+	CopySourceIfMatch string            // if set, the copy fails with ErrorPreconditionFailed if the source's ETag no longer matches
+}
+
+func (c *Client) CopyS3ToS3(srcKey string, destKey string) error {
+	return c.CopyS3ToS3WithOptions(srcKey, destKey, CopyOptions{})
+}
+
+// setCopyObjectHeaders sets the input's object headers and metadata to the source's, for copies which replace them
+func setCopyObjectHeaders(input *s3.CopyObjectInput, src *s3.HeadObjectOutput) {
+	input.ContentType = src.ContentType
+	input.ContentEncoding = src.ContentEncoding
+	input.ContentDisposition = src.ContentDisposition
+	input.ContentLanguage = src.ContentLanguage
+	input.CacheControl = src.CacheControl
+	input.WebsiteRedirectLocation = src.WebsiteRedirectLocation
+	input.Metadata = src.Metadata
+	if expires, err := http.ParseTime(aws.StringValue(src.Expires)); err == nil {
+		input.Expires = aws.Time(expires)
+	}
+}
+
+func (c *Client) CopyS3ToS3WithOptions(srcKey string, destKey string, options CopyOptions) error {
+	destKey, err := c.uploadKey(destKey)
+	if err != nil {
+		return err
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:               aws.String(c.Bucket),
+		Key:                  aws.String(destKey),
+		CopySource:           aws.String(c.copySource(srcKey)),
+		ACL:                  aws.String("private"),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+		ServerSideEncryption: c.serverSideEncryption(),
+	}
+	if options.ACL != "" {
+		input.ACL = aws.String(options.ACL)
+	}
+	if options.StorageClass != "" {
+		input.StorageClass = aws.String(options.StorageClass)
+	}
+	if options.CopySourceIfMatch != "" {
+		input.CopySourceIfMatch = aws.String(options.CopySourceIfMatch)
+	}
+	if options.Metadata != nil || options.ContentType != "" {
+		// S3 resets the object's headers and metadata when replacing either of them, so the source's are provided if not overridden
+		headCtx, headCancel := c.s3Context()
+		defer headCancel()
+		src, err := c.s3API().HeadObjectWithContext(headCtx, &s3.HeadObjectInput{
+			Bucket: aws.String(c.Bucket),
+			Key:    aws.String(srcKey),
+		}, c.s3RequestOptions()...)
+		if err != nil {
+			return errors.Wrap(err, srcKey)
+		}
+		input.MetadataDirective = aws.String(s3.MetadataDirectiveReplace)
+		setCopyObjectHeaders(input, src)
+		if options.Metadata != nil {
+			input.Metadata = aws.StringMap(options.Metadata)
+		}
+		if options.ContentType != "" {
+			input.ContentType = aws.String(options.ContentType)
+		}
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err = c.s3API().CopyObjectWithContext(ctx, input, c.s3RequestOptions()...)
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusPreconditionFailed {
+		return ErrorPreconditionFailed(srcKey, options.CopySourceIfMatch)
+	}
+	return errors.Wrap(err, srcKey, destKey)
+}
+
+// ReadFirstObjectUnderPrefix reads the first object (by key) under prefix, and returns ErrorS3PrefixEmpty if there are none
+func (c *Client) ReadFirstObjectUnderPrefix(prefix string) (string, []byte, error) {
+	output, err := c.listObjectsV2Page(aws.BackgroundContext(), &s3.ListObjectsV2Input{
+		Bucket:  aws.String(c.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int64(1),
+	})
+	if err != nil {
+		return "", nil, errors.Wrap(err, prefix)
+	}
+	if len(output.Contents) == 0 {
+		return "", nil, ErrorS3PrefixEmpty(prefix)
+	}
+
+	key := *output.Contents[0].Key
+	data, err := c.ReadBytesFromS3(key)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, data, nil
+}
+
+// ListPrefixModifiedSince returns all objects under the prefix which were last modified at or after since
+func (c *Client) ListPrefixModifiedSince(prefix string, since time.Time) ([]*s3.Object, error) {
+	var objects []*s3.Object
+	err := c.listObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range output.Contents {
+			if !aws.TimeValue(object.LastModified).Before(since) {
+				objects = append(objects, object)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, prefix)
+	}
+	return objects, nil
+}
+
+// DeleteS3ObjectsOlderThan deletes the objects under prefix older than age, and returns how many were (or would be) deleted
+func (c *Client) DeleteS3ObjectsOlderThan(prefix string, age time.Duration, dryRun bool) (int64, error) {
+	cutoff := time.Now().Add(-age)
+
+	objects, err := c.listS3Objects(prefix)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for _, object := range objects {
+		if aws.TimeValue(object.LastModified).Before(cutoff) {
+			keys = append(keys, *object.Key)
+		}
+	}
+
+	if dryRun || len(keys) == 0 {
+		return int64(len(keys)), nil
+	}
+
+	if err := c.DeleteS3Keys(keys); err != nil {
+		deleted := int64(len(keys))
+		if batchErr, ok := errors.Cause(err).(*S3BatchError); ok {
+			deleted -= int64(len(batchErr.Errors))
+		} else {
+			deleted = 0 // a whole batch failed, so it's unknown which (if any) were deleted
+		}
+		return deleted, errors.Wrap(err, prefix)
+	}
+	return int64(len(keys)), nil
+}
+
+// ListPrefixSinceLocalMax lists the objects under prefix which were modified after the newest file in localDir
+func (c *Client) ListPrefixSinceLocalMax(prefix string, localDir string) ([]*s3.Object, error) {
+	if err := files.CheckDir(localDir); err != nil {
+		return nil, err
+	}
+	localPaths, err := files.ListDirRecursive(localDir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var localMax time.Time
+	for _, localPath := range localPaths {
+		fileInfo, err := os.Stat(localPath)
+		if err != nil {
+			return nil, errors.Wrap(err, localPath)
+		}
+		if fileInfo.ModTime().After(localMax) {
+			localMax = fileInfo.ModTime()
+		}
+	}
+
+	var objects []*s3.Object
+	err = c.listObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range output.Contents {
+			if aws.TimeValue(object.LastModified).After(localMax) {
+				objects = append(objects, object)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, prefix)
+	}
+	return objects, nil
+}
+
+func (c *Client) listS3Objects(prefix string) ([]*s3.Object, error) {
+	var objects []*s3.Object
+	err := c.listObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		objects = append(objects, output.Contents...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, prefix)
+	}
+	return objects, nil
+}
+
+func (c *Client) listS3Keys(prefix string) ([]string, error) {
+	objects, err := c.listS3Objects(prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(objects))
+	for i, object := range objects {
+		keys[i] = *object.Key
+	}
+	return keys, nil
+}
+
+// S3PrefixDiff holds sorted keys relative to the compared prefixes
+type S3PrefixDiff struct {
+	OnlyInA   []string
+	OnlyInB   []string
+	Different []string // in both prefixes, but with a different size or content (see DiffS3Prefixes)
+}
+
+// HashS3Prefix returns the SHA256 of the relative keys and ETags of the objects under prefix
+func (c *Client) HashS3Prefix(prefix string) (string, error) {
+	objects, err := c.listS3Objects(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return *objects[i].Key < *objects[j].Key
+	})
+
+	hash := sha256.New()
+	for _, object := range objects {
+		// keys can't contain null characters, so each pair is unambiguous
+		fmt.Fprintf(hash, "%s\x00%s\x00", strings.TrimPrefix(*object.Key, prefix), aws.StringValue(object.ETag))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// IsMultipartETag returns whether the ETag is of an object uploaded in parts (e.g. "abc-3"), which is not the MD5 of its content
+func IsMultipartETag(etag string) bool {
+	etag = strings.Trim(etag, `"`)
+	dashIndex := strings.LastIndex(etag, "-")
+	if dashIndex <= 0 {
+		return false
+	}
+	numParts, err := strconv.Atoi(etag[dashIndex+1:])
+	return err == nil && numParts > 0
+}
+
+// DiffS3Prefixes compares the objects under two prefixes by their relative keys and ETags
+func (c *Client) DiffS3Prefixes(prefixA string, prefixB string) (*S3PrefixDiff, error) {
+	var objectsA, objectsB []*s3.Object
+	err := parallel.RunFirstErr(
+		func() error {
+			var err error
+			objectsA, err = c.listS3Objects(prefixA)
+			return err
+		},
+		func() error {
+			var err error
+			objectsB, err = c.listS3Objects(prefixB)
+			return err
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	objectsByRelKeyB := make(map[string]*s3.Object, len(objectsB))
+	for _, object := range objectsB {
+		objectsByRelKeyB[strings.TrimPrefix(*object.Key, prefixB)] = object
+	}
+
+	diff := &S3PrefixDiff{}
+	var multipartRelKeys []string
+	for _, objectA := range objectsA {
+		relKey := strings.TrimPrefix(*objectA.Key, prefixA)
+		objectB, ok := objectsByRelKeyB[relKey]
+		if !ok {
+			diff.OnlyInA = append(diff.OnlyInA, relKey)
+			continue
+		}
+		delete(objectsByRelKeyB, relKey)
+		etagA, etagB := aws.StringValue(objectA.ETag), aws.StringValue(objectB.ETag)
+		switch {
+		case aws.Int64Value(objectA.Size) != aws.Int64Value(objectB.Size):
+			diff.Different = append(diff.Different, relKey)
+		case IsMultipartETag(etagA) || IsMultipartETag(etagB):
+			// equal content can have different multipart ETags (e.g. if the part sizes differ), so compare stored hashes instead
+			multipartRelKeys = append(multipartRelKeys, relKey)
+		case etagA != etagB:
+			diff.Different = append(diff.Different, relKey)
+		}
+	}
+	for relKey := range objectsByRelKeyB {
+		diff.OnlyInB = append(diff.OnlyInB, relKey)
+	}
+
+	if len(multipartRelKeys) > 0 {
+		keys := make([]string, 0, 2*len(multipartRelKeys))
+		for _, relKey := range multipartRelKeys {
+			keys = append(keys, prefixA+relKey, prefixB+relKey)
+		}
+		metadatas, err := c.GetS3ObjectsMetadata(keys...)
+		if err != nil {
+			return nil, err
+		}
+		// objects without stored hashes are assumed to be equal, since their sizes match
+		for _, relKey := range multipartRelKeys {
+			var hashA, hashB string
+			if metadata, ok := metadatas[prefixA+relKey]; ok {
+				hashA = metadataValue(metadata.Metadata, ContentHashMetadataKey)
+			}
+			if metadata, ok := metadatas[prefixB+relKey]; ok {
+				hashB = metadataValue(metadata.Metadata, ContentHashMetadataKey)
+			}
+			if hashA != "" && hashB != "" && hashA != hashB {
+				diff.Different = append(diff.Different, relKey)
+			}
+		}
+	}
+
+	sort.Strings(diff.OnlyInA)
+	sort.Strings(diff.OnlyInB)
+	sort.Strings(diff.Different)
+	return diff, nil
+}
+
+// PurgeAllVersions permanently deletes every version and delete marker under the prefix
+func (c *Client) PurgeAllVersions(prefix string) error {
+	if strings.TrimSpace(prefix) == "" {
+		return ErrorS3DeleteAllNotAllowed(c.Bucket)
+	}
+
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	batchErr := &S3BatchError{Errors: map[string]error{}}
+
+	for {
+		ctx, cancel := c.s3Context()
+		output, err := c.s3API().ListObjectVersionsWithContext(ctx, input, c.s3RequestOptions()...)
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, prefix)
+		}
+
+		var deleteObjects []*s3.ObjectIdentifier
+		for _, version := range output.Versions {
+			deleteObjects = append(deleteObjects, &s3.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+		}
+		for _, marker := range output.DeleteMarkers {
+			deleteObjects = append(deleteObjects, &s3.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+		}
+
+		// a page has at most 1000 versions and markers, which is the DeleteObjects limit
+		if len(deleteObjects) > 0 {
+			ctx, cancel := c.s3Context()
+			deleteOutput, err := c.s3API().DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(c.Bucket),
+				Delete: &s3.Delete{
+					Objects: deleteObjects,
+					Quiet:   aws.Bool(true),
+				},
+			}, c.s3RequestOptions()...)
+			cancel()
+			if err != nil {
+				return errors.Wrap(err, prefix)
+			}
+			for _, deleteErr := range deleteOutput.Errors {
+				versionKey := fmt.Sprintf("%s?versionId=%s", aws.StringValue(deleteErr.Key), aws.StringValue(deleteErr.VersionId))
+				batchErr.Errors[versionKey] = awserr.New(aws.StringValue(deleteErr.Code), aws.StringValue(deleteErr.Message), nil)
+			}
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			break
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.VersionIdMarker = output.NextVersionIdMarker
+	}
+
+	if len(batchErr.Errors) > 0 {
+		return errors.Wrap(batchErr, prefix)
+	}
+	return nil
+}
+
+// DeleteS3Keys deletes the keys in batches; if any keys could not be deleted, an *S3BatchError with each failure is returned
+func (c *Client) DeleteS3Keys(keys []string) error {
+	batchErr := &S3BatchError{Errors: map[string]error{}}
+
+	for start := 0; start < len(keys); start += _s3MaxDeleteObjects {
+		end := start + _s3MaxDeleteObjects
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		deleteObjects := make([]*s3.ObjectIdentifier, end-start)
+		for i, key := range keys[start:end] {
+			deleteObjects[i] = &s3.ObjectIdentifier{Key: aws.String(key)}
+		}
+
+		ctx, cancel := c.s3Context()
+		output, err := c.s3API().DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.Bucket),
+			Delete: &s3.Delete{
+				Objects: deleteObjects,
+				Quiet:   aws.Bool(true),
+			},
+		}, c.s3RequestOptions()...)
+		cancel()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		for _, deleteErr := range output.Errors {
+			key := aws.StringValue(deleteErr.Key)
+			batchErr.Errors[key] = awserr.New(aws.StringValue(deleteErr.Code), aws.StringValue(deleteErr.Message), nil)
+		}
+	}
+
+	if len(batchErr.Errors) > 0 {
+		return errors.WithStack(batchErr)
+	}
+	return nil
+}
+
+// PublishS3ObjectSet uploads items under stagingPrefix, and copies them to finalPrefix only once they have all been uploaded
+func (c *Client) PublishS3ObjectSet(items map[string][]byte, stagingPrefix string, finalPrefix string) error {
+	relKeys := make([]string, 0, len(items))
+	for relKey := range items {
+		relKeys = append(relKeys, relKey)
+	}
+	sort.Strings(relKeys)
+
+	stagingKeys := make([]string, len(relKeys))
+	for i, relKey := range relKeys {
+		stagingKeys[i] = S3KeyJoin(stagingPrefix, relKey)
+	}
+	defer c.DeleteS3Keys(stagingKeys) // best-effort
+
+	fns := make([]func() error, len(relKeys))
+	for i := range relKeys {
+		data, stagingKey := items[relKeys[i]], stagingKeys[i]
+		fns[i] = func() error {
+			if err := c.UploadBytesToS3(data, stagingKey); err != nil {
+				return err
+			}
+			metadata, err := c.GetS3ObjectMetadata(stagingKey)
+			if err != nil {
+				return err
+			}
+			if metadata.Size != int64(len(data)) {
+				return ErrorS3IntegrityCheckFailed(stagingKey, len(data), int(metadata.Size))
+			}
+			return nil
+		}
+	}
+	if err := parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...); err != nil {
+		return err
+	}
+
+	for i := range relKeys {
+		relKey, stagingKey := relKeys[i], stagingKeys[i]
+		fns[i] = func() error {
+			return c.CopyS3ToS3(stagingKey, S3KeyJoin(finalPrefix, relKey))
+		}
+	}
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
+}
+
+// RenameS3Prefix copies every object under oldPrefix to newPrefix, and deletes the originals only if all copies succeed
+func (c *Client) RenameS3Prefix(oldPrefix string, newPrefix string) error {
+	if oldPrefix == newPrefix {
+		return nil
+	}
+
+	keys, err := c.listS3Keys(oldPrefix)
+	if err != nil {
+		return err
+	}
+
+	fns := make([]func() error, len(keys))
+	for i, key := range keys {
+		key := key
+		fns[i] = func() error {
+			return c.CopyS3ToS3(key, newPrefix+strings.TrimPrefix(key, oldPrefix))
+		}
+	}
+	if err := parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...); err != nil {
+		return err
+	}
+
+	return errors.Wrap(c.DeleteS3Keys(keys), oldPrefix)
+}
+
+type MultipartUploadOptions struct {
+	PartSize       int64 // defaults to the client's S3PartSize, or s3manager.DefaultUploadPartSize (S3's minimum)
+	Concurrency    int   // the number of parts uploaded in parallel; defaults to the client's S3Concurrency, or s3manager.DefaultUploadConcurrency
+	MaxPartRetries int   // the number of times a failed part is retried (with backoff) before the upload is aborted
+}
+
+// UploadMultipartToS3 uploads the reader in parts, retrying failed parts, and aborts the upload if a part still fails
+func (c *Client) UploadMultipartToS3(r io.Reader, key string, options MultipartUploadOptions) error {
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return err
+	}
+
+	uploadID, err := c.CreateS3MultipartUpload(key)
+	if err != nil {
+		return err
+	}
+
+	if err := c.ResumeMultipartUploadToS3(r, key, uploadID, nil, options); err != nil {
+		c.abortMultipartUpload(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// CreateS3MultipartUpload starts a multipart upload and returns its ID (see ResumeMultipartUploadToS3)
+func (c *Client) CreateS3MultipartUpload(key string) (string, error) {
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+
+	created, err := c.s3API().CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(c.Bucket),
+		Key:                  aws.String(key),
+		ACL:                  aws.String("private"),
+		ContentDisposition:   aws.String("attachment"),
+		ServerSideEncryption: c.serverSideEncryption(),
+	}, c.s3RequestOptions()...)
+	if err != nil {
+		return "", errors.Wrap(err, key)
+	}
+	return *created.UploadId, nil
+}
+
+type PresignedMultipartUpload struct {
+	Key      string // the key the upload will complete to (see Client.UploadKeyValidation)
+	UploadID string
+	PartURLs []string // PartURLs[i] accepts a PUT of part i+1
+}
+
+// CreatePresignedMultipartUpload starts a multipart upload and presigns a URL for each of its parts
+func (c *Client) CreatePresignedMultipartUpload(key string, partCount int, expiry time.Duration) (*PresignedMultipartUpload, error) {
+	if partCount < 1 || partCount > s3manager.MaxUploadParts {
+		return nil, errors.New(key, fmt.Sprintf("invalid part count (%d): must be between 1 and %d", partCount, s3manager.MaxUploadParts))
+	}
+
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadID, err := c.CreateS3MultipartUpload(key)
+	if err != nil {
+		return nil, err
+	}
+
+	partURLs := make([]string, partCount)
+	for i := range partURLs {
+		req, _ := c.s3API().UploadPartRequest(&s3.UploadPartInput{
+			Bucket:     aws.String(c.Bucket),
+			Key:        aws.String(key),
+			UploadId:   aws.String(uploadID),
+			PartNumber: aws.Int64(int64(i + 1)),
+		})
+		partURLs[i], err = req.Presign(expiry)
+		if err != nil {
+			c.abortMultipartUpload(key, uploadID)
+			return nil, errors.Wrap(err, key)
+		}
+	}
+
+	return &PresignedMultipartUpload{
+		Key:      key,
+		UploadID: uploadID,
+		PartURLs: partURLs,
+	}, nil
+}
+
+// CompletePresignedMultipartUpload completes a presigned upload, given the ETag of each part (etags[i] is part i+1's)
+func (c *Client) CompletePresignedMultipartUpload(key string, uploadID string, etags []string) error {
+	if len(etags) == 0 {
+		return errors.New(key, "no parts were uploaded")
+	}
+
+	parts := make([]*s3.CompletedPart, len(etags))
+	for i, etag := range etags {
+		parts[i] = &s3.CompletedPart{
+			ETag:       aws.String(etag),
+			PartNumber: aws.Int64(int64(i + 1)),
+		}
+	}
+
+	return errors.Wrap(c.completeMultipartUpload(key, uploadID, parts), key)
+}
+
+// ResumeMultipartUploadToS3 uploads the parts of the reader which aren't in completedParts, and completes the upload
+func (c *Client) ResumeMultipartUploadToS3(r io.Reader, key string, uploadID string, completedParts []*s3.CompletedPart, options MultipartUploadOptions) error {
+	key, err := c.uploadKey(key)
+	if err != nil {
+		return err
+	}
+
+	if options.PartSize <= 0 {
+		options.PartSize = c.S3PartSize
+	}
+	if options.PartSize <= 0 {
+		options.PartSize = s3manager.DefaultUploadPartSize
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = c.S3Concurrency
+	}
+	if options.Concurrency <= 0 {
+		options.Concurrency = s3manager.DefaultUploadConcurrency
+	}
+
+	completed := make(map[int64]*s3.CompletedPart, len(completedParts))
+	for _, part := range completedParts {
+		completed[aws.Int64Value(part.PartNumber)] = part
+	}
+
+	parts, err := c.uploadParts(r, key, uploadID, completed, options)
+	if err == nil {
+		err = c.completeMultipartUpload(key, uploadID, parts)
+	}
+	return errors.Wrap(err, key)
+}
+
+// ListS3MultipartUploads returns the in-progress multipart uploads under the prefix
+func (c *Client) ListS3MultipartUploads(prefix string) ([]*s3.MultipartUpload, error) {
+	var uploads []*s3.MultipartUpload
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(c.Bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	for {
+		ctx, cancel := c.s3Context()
+		output, err := c.s3API().ListMultipartUploadsWithContext(ctx, input, c.s3RequestOptions()...)
+		cancel()
+		if err != nil {
+			return nil, errors.Wrap(err, prefix)
+		}
+		uploads = append(uploads, output.Uploads...)
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return uploads, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
+
+// ListS3MultipartUploadParts returns the parts which have been uploaded to an in-progress multipart upload
+func (c *Client) ListS3MultipartUploadParts(key string, uploadID string) ([]*s3.CompletedPart, error) {
+	var parts []*s3.CompletedPart
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}
+
+	for {
+		ctx, cancel := c.s3Context()
+		output, err := c.s3API().ListPartsWithContext(ctx, input, c.s3RequestOptions()...)
+		cancel()
+		if err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+		for _, part := range output.Parts {
+			parts = append(parts, &s3.CompletedPart{ETag: part.ETag, PartNumber: part.PartNumber})
+		}
+
+		if !aws.BoolValue(output.IsTruncated) {
+			return parts, nil
+		}
+		input.PartNumberMarker = output.NextPartNumberMarker
+	}
+}
+
+// AbortStaleMultipartUploads aborts the multipart uploads under the prefix which were initiated more than olderThan ago
+func (c *Client) AbortStaleMultipartUploads(prefix string, olderThan time.Duration) error {
+	uploads, err := c.ListS3MultipartUploads(prefix)
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-olderThan)
+	var fns []func() error
+	for _, upload := range uploads {
+		upload := upload
+		if !aws.TimeValue(upload.Initiated).Before(cutoff) {
+			continue
+		}
+		fns = append(fns, func() error {
+			return errors.Wrap(c.abortMultipartUpload(*upload.Key, *upload.UploadId), *upload.Key)
+		})
+	}
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
+}
+
+func (c *Client) completeMultipartUpload(key string, uploadID string, parts []*s3.CompletedPart) error {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := c.s3API().CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(c.Bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	}, c.s3RequestOptions()...)
+	return err
+}
+
+// abortMultipartUpload's error can be ignored after a failed upload (AbortStaleMultipartUploads can clean it up)
+func (c *Client) abortMultipartUpload(key string, uploadID string) error {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := c.s3API().AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(c.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}, c.s3RequestOptions()...)
+	return err
+}
+
+// uploadParts reads the parts sequentially and uploads the ones which aren't completed concurrently, until one fails
+func (c *Client) uploadParts(r io.Reader, key string, uploadID string, completed map[int64]*s3.CompletedPart, options MultipartUploadOptions) ([]*s3.CompletedPart, error) {
+	var parts []*s3.CompletedPart
+	var firstErr error
+	var mux sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, options.Concurrency)
+	ctx, cancel := context.WithCancel(aws.BackgroundContext())
+	defer cancel()
+
+	failed := func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		return firstErr != nil
+	}
+
+	for partNumber := int64(1); !failed(); partNumber++ {
+		data := make([]byte, options.PartSize)
+		n, readErr := io.ReadFull(r, data)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			mux.Lock()
+			firstErr = readErr
+			mux.Unlock()
+			break
+		}
+		// an empty reader is uploaded as a single empty part
+		if n == 0 && partNumber > 1 {
+			break
+		}
+
+		if part, ok := completed[partNumber]; ok {
+			mux.Lock()
+			parts = append(parts, part)
+			mux.Unlock()
+			if readErr != nil {
+				break
+			}
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(partNumber int64, data []byte) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			part, err := c.uploadPartWithRetries(ctx, key, uploadID, partNumber, data, options.MaxPartRetries)
+			mux.Lock()
+			defer mux.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			parts = append(parts, part)
+		}(partNumber, data[:n])
+
+		if readErr != nil {
+			break
+		}
+	}
+
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool {
+		return *parts[i].PartNumber < *parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// parentCtx is cancelled when another part fails; each attempt has its own OperationTimeout
+func (c *Client) uploadPartWithRetries(parentCtx aws.Context, key string, uploadID string, partNumber int64, data []byte, maxRetries int) (*s3.CompletedPart, error) {
+	delay := _multipartPartRetryInitialDelay
+	for retries := 0; ; retries++ {
+		ctx, cancel := c.s3ContextWithParent(parentCtx)
+		output, err := c.s3API().UploadPartWithContext(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String(c.Bucket),
+			Key:        aws.String(key),
+			UploadId:   aws.String(uploadID),
+			PartNumber: aws.Int64(partNumber),
+			Body:       bytes.NewReader(data),
+		}, c.s3RequestOptions()...)
+		cancel()
+		if err == nil {
+			return &s3.CompletedPart{ETag: output.ETag, PartNumber: aws.Int64(partNumber)}, nil
+		}
+		if retries >= maxRetries || parentCtx.Err() != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("part %d", partNumber))
+		}
+
+		select {
+		case <-parentCtx.Done():
+			return nil, errors.Wrap(err, fmt.Sprintf("part %d", partNumber))
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > _multipartPartRetryMaxDelay {
+			delay = _multipartPartRetryMaxDelay
+		}
+	}
+}
+
+func (c *Client) GetS3ObjectTags(key string) (map[string]string, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(c.Bucket),
+		Key:    aws.String(key),
+	}, c.s3RequestOptions()...)
 	if err != nil {
-		return false, err
+		return nil, errors.Wrap(err, key)
 	}
-	return c.IsS3File(keys...)
-}
 
-func (c *Client) IsS3PathPrefix(s3Paths ...string) (bool, error) {
-	prefixes, err := c.ExractS3PathPrefixes(s3Paths...)
-	if err != nil {
-		return false, err
+	tags := make(map[string]string, len(output.TagSet))
+	for _, tag := range output.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
 	}
-	return c.IsS3Prefix(prefixes...)
+	return tags, nil
 }
 
-func (c *Client) IsS3PathDir(s3Paths ...string) (bool, error) {
-	dirPaths, err := c.ExractS3PathPrefixes(s3Paths...)
-	if err != nil {
-		return false, err
+func (c *Client) PutS3ObjectTags(key string, tags map[string]string) error {
+	tagSet := make([]*s3.Tag, 0, len(tags))
+	for _, tagKey := range maps.StrMapSortedKeys(tags) {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(tagKey), Value: aws.String(tags[tagKey])})
 	}
-	return c.IsS3Prefix(dirPaths...)
-}
 
-func (c *Client) UploadBytesToS3(data []byte, key string) error {
-	_, err := c.S3.PutObject(&s3.PutObjectInput{
-		Body:                 bytes.NewReader(data),
-		Key:                  aws.String(key),
-		Bucket:               aws.String(c.Bucket),
-		ACL:                  aws.String("private"),
-		ContentDisposition:   aws.String("attachment"),
-		ServerSideEncryption: aws.String("AES256"),
-	})
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := c.s3API().PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(c.Bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	}, c.s3RequestOptions()...)
 	return errors.Wrap(err, key)
 }
 
-func (c *Client) UploadBytesesToS3(data []byte, keys ...string) error {
+// TagS3Prefix adds the tags to every object under the prefix (keeping their other tags)
+func (c *Client) TagS3Prefix(prefix string, tags map[string]string) error {
+	return c.TagS3PrefixWithOptions(prefix, tags, false)
+}
+
+// TagS3PrefixWithOptions tags every object under the prefix; if replace is true, the objects' existing tags are removed
+func (c *Client) TagS3PrefixWithOptions(prefix string, tags map[string]string, replace bool) error {
+	keys, err := c.listS3Keys(prefix)
+	if err != nil {
+		return err
+	}
+
 	fns := make([]func() error, len(keys))
 	for i, key := range keys {
 		key := key
 		fns[i] = func() error {
-			return c.UploadBytesToS3(data, key)
+			if replace {
+				return c.PutS3ObjectTags(key, tags)
+			}
+
+			existingTags, err := c.GetS3ObjectTags(key)
+			if err != nil {
+				return err
+			}
+			return c.PutS3ObjectTags(key, maps.MergeStrMaps(existingTags, tags))
 		}
 	}
-	return parallel.RunFirstErr(fns...)
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
 }
 
-func (c *Client) UploadFileToS3(filePath string, key string) error {
-	data, err := files.ReadFileBytes(filePath)
+// ConcatS3Objects concatenates the source objects (in order) into destKey, server-side if their sizes allow it
+func (c *Client) ConcatS3Objects(srcKeys []string, destKey string) error {
+	destKey, err := c.uploadKey(destKey)
 	if err != nil {
 		return err
 	}
-	return c.UploadBytesToS3(data, key)
-}
-
-func (c *Client) UploadBufferToS3(buffer *bytes.Buffer, key string) error {
-	return c.UploadBytesToS3(buffer.Bytes(), key)
-}
 
-func (c *Client) UploadStringToS3(str string, key string) error {
-	str = strings.TrimSpace(str)
-	return c.UploadBytesToS3([]byte(str), key)
-}
+	if len(srcKeys) == 0 {
+		return c.UploadBytesToS3([]byte{}, destKey)
+	}
 
-func (c *Client) UploadJSONToS3(obj interface{}, key string) error {
-	jsonBytes, err := json.Marshal(obj)
+	metadatas, err := c.GetS3ObjectsMetadata(srcKeys...)
 	if err != nil {
 		return err
 	}
-	return c.UploadBytesToS3(jsonBytes, key)
-}
+	canCopyParts := true
+	for i, srcKey := range srcKeys {
+		metadata, ok := metadatas[srcKey]
+		if !ok {
+			return errors.Wrap(awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil), srcKey)
+		}
+		if i < len(srcKeys)-1 && metadata.Size < _s3MinPartSize {
+			canCopyParts = false
+		}
+	}
 
-func (c *Client) ReadJSONFromS3(objPtr interface{}, key string) error {
-	jsonBytes, err := c.ReadBytesFromS3(key)
-	if err != nil {
-		return err
+	if !canCopyParts {
+		var buf bytes.Buffer
+		for _, srcKey := range srcKeys {
+			data, err := c.ReadBytesFromS3(srcKey)
+			if err != nil {
+				return err
+			}
+			buf.Write(data)
+		}
+		return c.UploadBytesToS3(buf.Bytes(), destKey)
 	}
-	return errors.Wrap(json.Unmarshal(jsonBytes, objPtr), key)
-}
 
-func (c *Client) UploadMsgpackToS3(obj interface{}, key string) error {
-	msgpackBytes, err := msgpack.Marshal(obj)
-	if err != nil {
-		return err
+	var copyParts []*s3.UploadPartCopyInput
+	for _, srcKey := range srcKeys {
+		for _, copySourceRange := range copySourceRanges(metadatas[srcKey].Size) {
+			copyParts = append(copyParts, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(c.Bucket),
+				Key:             aws.String(destKey),
+				PartNumber:      aws.Int64(int64(len(copyParts) + 1)),
+				CopySource:      aws.String(c.copySource(srcKey)),
+				CopySourceRange: copySourceRange,
+			})
+		}
+	}
+	if len(copyParts) > s3manager.MaxUploadParts {
+		return errors.New(destKey, fmt.Sprintf("unable to concatenate %d objects: they would be copied in %d parts, but a multipart upload can have at most %d", len(srcKeys), len(copyParts), s3manager.MaxUploadParts))
 	}
-	return c.UploadBytesToS3(msgpackBytes, key)
-}
 
-func (c *Client) ReadMsgpackFromS3(objPtr interface{}, key string) error {
-	msgpackBytes, err := c.ReadBytesFromS3(key)
+	uploadID, err := c.CreateS3MultipartUpload(destKey)
 	if err != nil {
 		return err
 	}
-	return errors.Wrap(msgpack.Unmarshal(msgpackBytes, objPtr), key)
-}
 
-func (c *Client) ReadStringFromS3(key string) (string, error) {
-	response, err := c.S3.GetObject(&s3.GetObjectInput{
-		Key:    aws.String(key),
-		Bucket: aws.String(c.Bucket),
-	})
+	parts := make([]*s3.CompletedPart, len(copyParts))
+	fns := make([]func() error, len(copyParts))
+	for i, input := range copyParts {
+		i, input := i, input
+		input.UploadId = aws.String(uploadID)
+		fns[i] = func() error {
+			ctx, cancel := c.s3Context()
+			defer cancel()
+			output, err := c.s3API().UploadPartCopyWithContext(ctx, input, c.s3RequestOptions()...)
+			if err != nil {
+				return errors.Wrap(err, aws.StringValue(input.CopySource))
+			}
+			parts[i] = &s3.CompletedPart{ETag: output.CopyPartResult.ETag, PartNumber: input.PartNumber}
+			return nil
+		}
+	}
 
+	err = parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
+	if err == nil {
+		err = c.completeMultipartUpload(destKey, uploadID, parts)
+	}
 	if err != nil {
-		return "", errors.Wrap(err, key)
+		c.abortMultipartUpload(destKey, uploadID)
+		return errors.Wrap(err, destKey)
 	}
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(response.Body)
-	return buf.String(), nil
+	return nil
 }
 
-func (c *Client) ReadBytesFromS3(key string) ([]byte, error) {
-	response, err := c.S3.GetObject(&s3.GetObjectInput{
-		Key:    aws.String(key),
-		Bucket: aws.String(c.Bucket),
-	})
-
-	if err != nil {
-		return nil, errors.Wrap(err, key)
+// copySourceRanges returns the CopySourceRanges of an object's parts (nil if it fits in a single part)
+func copySourceRanges(size int64) []*string {
+	if size <= _s3MaxCopyPartSize {
+		return []*string{nil}
 	}
 
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(response.Body)
-	return buf.Bytes(), nil
+	numRanges := (size + _s3MaxCopyPartSize - 1) / _s3MaxCopyPartSize
+	rangeSize := (size + numRanges - 1) / numRanges
+	var ranges []*string
+	for start := int64(0); start < size; start += rangeSize {
+		end := start + rangeSize - 1
+		if end > size-1 {
+			end = size - 1
+		}
+		ranges = append(ranges, aws.String(fmt.Sprintf("bytes=%d-%d", start, end)))
+	}
+	return ranges
 }
 
-func (c *Client) ListPrefix(prefix string, maxResults int64) ([]*s3.Object, error) {
-	listObjectsInput := &s3.ListObjectsV2Input{
-		Bucket:  aws.String(c.Bucket),
-		Prefix:  aws.String(prefix),
-		MaxKeys: aws.Int64(maxResults),
+// ReencryptS3Object copies the object onto itself with the client's server-side encryption, preserving its metadata
+func (c *Client) ReencryptS3Object(key string) error {
+	encryption := serverSideEncryptionValue(c.ServerSideEncryption)
+	if encryption == nil {
+		encryption = aws.String(s3.ServerSideEncryptionAes256) // copying without encryption wouldn't re-encrypt the object
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:               aws.String(c.Bucket),
+		Key:                  aws.String(key),
+		CopySource:           aws.String(c.copySource(key)),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+		ServerSideEncryption: encryption,
+	}
+
+	requestClient := c
+	if c.SSECustomerKey != "" {
+		sseFields := sseCustomerKeyFields(c.SSECustomerAlgorithm, c.SSECustomerKey)
+		input.CopySourceSSECustomerAlgorithm = aws.String(sseFields["CopySourceSSECustomerAlgorithm"])
+		input.CopySourceSSECustomerKey = aws.String(sseFields["CopySourceSSECustomerKey"])
+		input.CopySourceSSECustomerKeyMD5 = aws.String(sseFields["CopySourceSSECustomerKeyMD5"])
+
+		// S3 rejects copies whose destination has both SSE-C and server-side encryption
+		clientCopy := *c
+		clientCopy.SSECustomerKey = ""
+		requestClient = &clientCopy
 	}
 
-	output, err := c.S3.ListObjectsV2(listObjectsInput)
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := requestClient.s3API().CopyObjectWithContext(ctx, input, requestClient.s3RequestOptions()...)
+	return errors.Wrap(err, key)
+}
+
+func (c *Client) ReencryptS3Prefix(prefix string) error {
+	keys, err := c.listS3Keys(prefix)
 	if err != nil {
-		return nil, errors.Wrap(err, prefix)
+		return err
 	}
 
-	return output.Contents, nil
+	fns := make([]func() error, len(keys))
+	for i, key := range keys {
+		key := key
+		fns[i] = func() error {
+			return c.ReencryptS3Object(key)
+		}
+	}
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
 }
 
-func (c *Client) DeleteFromS3ByPrefix(prefix string, continueIfFailure bool) error {
-	listObjectsInput := &s3.ListObjectsV2Input{
-		Bucket:  aws.String(c.Bucket),
-		Prefix:  aws.String(prefix),
-		MaxKeys: aws.Int64(1000),
+// TransitionS3PrefixStorageClass copies every object under prefix onto itself with storageClass (unless it's already in it)
+func (c *Client) TransitionS3PrefixStorageClass(prefix string, storageClass string) error {
+	if !slices.HasString(_s3StorageClasses, storageClass) {
+		return ErrorInvalidS3StorageClass(storageClass)
 	}
 
-	var subErr error
+	objects, err := c.listS3Objects(prefix)
+	if err != nil {
+		return err
+	}
 
-	err := c.S3.ListObjectsV2Pages(listObjectsInput,
-		func(listObjectsOutput *s3.ListObjectsV2Output, lastPage bool) bool {
-			deleteObjects := make([]*s3.ObjectIdentifier, len(listObjectsOutput.Contents))
-			for i, object := range listObjectsOutput.Contents {
-				deleteObjects[i] = &s3.ObjectIdentifier{Key: object.Key}
-			}
-			deleteObjectsInput := &s3.DeleteObjectsInput{
-				Bucket: aws.String(c.Bucket),
-				Delete: &s3.Delete{
-					Objects: deleteObjects,
-					Quiet:   aws.Bool(true),
-				},
-			}
-			_, newSubErr := c.S3.DeleteObjects(deleteObjectsInput)
-			if newSubErr != nil {
-				subErr = newSubErr
-				if !continueIfFailure {
-					return false
-				}
-			}
-			return true
+	var fns []func() error
+	for _, object := range objects {
+		if aws.StringValue(object.StorageClass) == storageClass {
+			continue
+		}
+		key := *object.Key
+		fns = append(fns, func() error {
+			ctx, cancel := c.s3Context()
+			defer cancel()
+			_, err := c.s3API().CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+				Bucket:               aws.String(c.Bucket),
+				Key:                  aws.String(key),
+				CopySource:           aws.String(c.copySource(key)),
+				MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+				StorageClass:         aws.String(storageClass),
+				ServerSideEncryption: c.serverSideEncryption(),
+			}, c.s3RequestOptions()...)
+			return errors.Wrap(err, key)
 		})
-
-	if subErr != nil {
-		return errors.Wrap(subErr, prefix)
 	}
-	return errors.Wrap(err, prefix)
+	return parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...)
 }
 
 func IsValidS3Path(s3Path string) bool {
 	if !strings.HasPrefix(s3Path, "s3://") {
 		return false
 	}
-	parts := strings.Split(s3Path[5:], "/")
-	if len(parts) < 2 {
+	fullPath := s3Path[len("s3://"):]
+	bucketEnd := s3PathBucketEnd(fullPath)
+	if bucketEnd <= 0 {
 		return false
 	}
-	if parts[0] == "" || parts[1] == "" {
+	key := fullPath[bucketEnd+1:]
+	if key == "" || strings.HasPrefix(key, "/") {
 		return false
 	}
 	return true
 }
 
+// s3PathBucketEnd returns the index of the slash which ends the bucket of an s3 path (without its scheme), or -1
+func s3PathBucketEnd(fullPath string) int {
+	start := 0
+	if IsS3AccessPointARN(fullPath) {
+		if i := strings.Index(fullPath, ":accesspoint/"); i >= 0 {
+			start = i + len(":accesspoint/")
+		}
+	}
+	end := strings.Index(fullPath[start:], "/")
+	if end < 0 {
+		return -1
+	}
+	return start + end
+}
+
 func IsValidS3aPath(s3aPath string) bool {
 	if !strings.HasPrefix(s3aPath, "s3a://") {
 		return false
@@ -337,7 +3520,7 @@ func SplitS3Path(s3Path string) (string, string, error) {
 		return "", "", ErrorInvalidS3aPath(s3Path)
 	}
 	fullPath := s3Path[len("s3://"):]
-	slashIndex := strings.Index(fullPath, "/")
+	slashIndex := s3PathBucketEnd(fullPath)
 	bucket := fullPath[0:slashIndex]
 	key := fullPath[slashIndex+1:]
 
@@ -359,11 +3542,298 @@ func (c *Client) ExractS3PathPrefixes(s3Paths ...string) ([]string, error) {
 	return prefixes, nil
 }
 
-func GetBucketRegion(bucket string) (string, error) {
+func (c *Client) ExtractS3aPathPrefixes(s3aPaths ...string) ([]string, error) {
+	prefixes := make([]string, len(s3aPaths))
+	for i, s3aPath := range s3aPaths {
+		bucket, prefix, err := SplitS3aPath(s3aPath)
+		if err != nil {
+			return nil, err
+		}
+		if bucket != c.Bucket {
+			return nil, errors.New(fmt.Sprintf("bucket of S3a path %s does not match client bucket (%s)", s3aPath, c.Bucket)) // unexpected
+		}
+		prefixes[i] = prefix
+	}
+	return prefixes, nil
+}
+
+var _lookupBucketRegion = func(bucket string) (string, error) {
 	sess := session.Must(session.NewSession())
-	region, err := s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucket, endpoints.UsWest2RegionID)
+	return s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucket, endpoints.UsWest2RegionID)
+}
+
+// GetBucketRegion retries transient failures (e.g. throttling) with backoff, but fails fast if the bucket
+// doesn't exist or can't be accessed
+func GetBucketRegion(bucket string) (string, error) {
+	delay := _s3ThrottleInitialDelay
+	for retries := 0; ; retries++ {
+		region, err := _lookupBucketRegion(bucket)
+		if err == nil {
+			return region, nil
+		}
+		if !isRetryableErr(err) || retries == _s3ThrottleMaxRetries {
+			return "", ErrorBucketInaccessible(bucket)
+		}
+
+		time.Sleep(delay)
+		delay *= 2
+		if delay > _s3ThrottleMaxDelay {
+			delay = _s3ThrottleMaxDelay
+		}
+	}
+}
+
+// VerifyS3Access checks that bucket exists and can be listed, so that misconfigured access fails fast
+func (c *Client) VerifyS3Access(bucket string) error {
+	if bucket == "" {
+		return ErrorBucketNotConfigured()
+	}
+
+	options := c.bucketRequestOptions(bucket)
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+
+	_, err := c.s3API().HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(bucket),
+	}, options...)
+	if err != nil {
+		return s3AccessErr(err, bucket, "access the bucket (s3:ListBucket)")
+	}
+
+	_, err = c.s3API().ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int64(0),
+	}, options...)
+	if err != nil {
+		return s3AccessErr(err, bucket, "list the bucket (s3:ListBucket)")
+	}
+
+	return nil
+}
+
+var _bucketNameCharsRegex = regexp.MustCompile(`^[a-z0-9.-]*$`)
+
+// ValidateBucketName checks the bucket name (or S3 access point ARN) against S3's naming rules
+func ValidateBucketName(bucket string) error {
+	if IsS3AccessPointARN(bucket) {
+		_, err := parseS3AccessPointARN(bucket)
+		return err
+	}
+
+	switch {
+	case len(bucket) < 3 || len(bucket) > 63:
+		return ErrorInvalidBucketName(bucket, "it must be between 3 and 63 characters long")
+	case !_bucketNameCharsRegex.MatchString(bucket):
+		return ErrorInvalidBucketName(bucket, "it can only contain lowercase letters, numbers, dots, and hyphens")
+	case !isLowercaseAlphanumeric(bucket[0]) || !isLowercaseAlphanumeric(bucket[len(bucket)-1]):
+		return ErrorInvalidBucketName(bucket, "it must start and end with a lowercase letter or number")
+	case strings.Contains(bucket, ".."):
+		return ErrorInvalidBucketName(bucket, "it cannot contain consecutive dots")
+	case strings.Contains(bucket, ".-") || strings.Contains(bucket, "-."):
+		return ErrorInvalidBucketName(bucket, "dots cannot be next to hyphens")
+	case net.ParseIP(bucket) != nil:
+		return ErrorInvalidBucketName(bucket, "it cannot be formatted as an IP address")
+	}
+	return nil
+}
+
+// IsS3AccessPointARN returns whether the bucket is given in ARN form (it may still be invalid; see ValidateBucketName)
+func IsS3AccessPointARN(bucket string) bool {
+	return arn.IsARN(bucket)
+}
+
+// parseS3AccessPointARN parses an access point ARN (the SDK routes requests with ARN buckets to the access point's endpoint)
+func parseS3AccessPointARN(bucket string) (arn.ARN, error) {
+	accessPointARN, err := arn.Parse(bucket)
+	if err != nil {
+		return arn.ARN{}, ErrorInvalidBucketName(bucket, err.Error())
+	}
+	if accessPointARN.Service != s3.ServiceName {
+		return arn.ARN{}, ErrorInvalidBucketName(bucket, "only S3 access point ARNs are supported")
+	}
+	if accessPointARN.Region == "" || accessPointARN.AccountID == "" {
+		return arn.ARN{}, ErrorInvalidBucketName(bucket, "access point ARNs must include a region and an account ID")
+	}
+
+	var name string
+	switch {
+	case strings.HasPrefix(accessPointARN.Resource, "accesspoint/"):
+		name = strings.TrimPrefix(accessPointARN.Resource, "accesspoint/")
+	case strings.HasPrefix(accessPointARN.Resource, "accesspoint:"):
+		name = strings.TrimPrefix(accessPointARN.Resource, "accesspoint:")
+	default:
+		return arn.ARN{}, ErrorInvalidBucketName(bucket, "only S3 access point ARNs are supported")
+	}
+	if name == "" || strings.ContainsAny(name, "/:") {
+		return arn.ARN{}, ErrorInvalidBucketName(bucket, "the access point name is missing or invalid")
+	}
+
+	return accessPointARN, nil
+}
+
+func isLowercaseAlphanumeric(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9')
+}
+
+// bucketRequestOptions returns the request options for calls to bucket, which may not be the client's bucket
+func (c *Client) bucketRequestOptions(bucket string) []request.Option {
+	// the expected bucket owner only applies to the client's bucket
+	if bucket == c.Bucket {
+		return c.s3RequestOptions()
+	}
+	return c.s3HookOptions()
+}
+
+// WaitForBucket polls for the bucket with backoff, and returns ErrorS3BucketNotFound if it doesn't exist within the timeout
+func (c *Client) WaitForBucket(bucket string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := _waitForBucketInitialDelay
+
+	for {
+		ctx, cancel := c.s3Context()
+		_, err := c.s3API().HeadBucketWithContext(ctx, &s3.HeadBucketInput{
+			Bucket: aws.String(bucket),
+		}, c.bucketRequestOptions(bucket)...)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if !IsNotFoundErr(err) && !IsNoSuchBucketErr(err) && !isRetryableErr(err) {
+			return errors.Wrap(err, bucket)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return ErrorS3BucketNotFound(bucket)
+		}
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > _waitForBucketMaxDelay {
+			delay = _waitForBucketMaxDelay
+		}
+	}
+}
+
+// GetBucketPolicy returns the bucket's policy document, or "" if it has none
+func (c *Client) GetBucketPolicy(bucket string) (string, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().GetBucketPolicyWithContext(ctx, &s3.GetBucketPolicyInput{
+		Bucket: aws.String(bucket),
+	}, c.bucketRequestOptions(bucket)...)
+	if CheckErrCode(err, "NoSuchBucketPolicy") {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrap(err, bucket)
+	}
+	return aws.StringValue(output.Policy), nil
+}
+
+// PutBucketPolicy replaces the bucket's policy; the policy is checked to be valid JSON before it is sent
+func (c *Client) PutBucketPolicy(bucket string, policyJSON string) error {
+	var policy map[string]interface{}
+	if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+		return ErrorInvalidBucketPolicy(bucket, err)
+	}
+
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := c.s3API().PutBucketPolicyWithContext(ctx, &s3.PutBucketPolicyInput{
+		Bucket: aws.String(bucket),
+		Policy: aws.String(policyJSON),
+	}, c.bucketRequestOptions(bucket)...)
+	return errors.Wrap(err, bucket)
+}
+
+func (c *Client) DeleteBucketPolicy(bucket string) error {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	_, err := c.s3API().DeleteBucketPolicyWithContext(ctx, &s3.DeleteBucketPolicyInput{
+		Bucket: aws.String(bucket),
+	}, c.bucketRequestOptions(bucket)...)
+	return errors.Wrap(err, bucket)
+}
+
+// ListBuckets returns the names of the buckets owned by the client's credentials (the client's bucket isn't used)
+func (c *Client) ListBuckets() ([]string, error) {
+	ctx, cancel := c.s3Context()
+	defer cancel()
+	output, err := c.s3API().ListBucketsWithContext(ctx, &s3.ListBucketsInput{}, c.s3HookOptions()...)
 	if err != nil {
-		return "", ErrorBucketInaccessible(bucket)
+		return nil, errors.WithStack(err)
+	}
+
+	buckets := make([]string, len(output.Buckets))
+	for i, bucket := range output.Buckets {
+		buckets[i] = aws.StringValue(bucket.Name)
+	}
+	return buckets, nil
+}
+
+// ListBucketsWithRegions returns the region of each bucket owned by the client's credentials
+func (c *Client) ListBucketsWithRegions() (map[string]string, error) {
+	buckets, err := c.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make(map[string]string, len(buckets))
+	var mux sync.Mutex
+
+	fns := make([]func() error, len(buckets))
+	for i, bucket := range buckets {
+		bucket := bucket
+		fns[i] = func() error {
+			region, err := GetBucketRegion(bucket)
+			if err != nil {
+				return err
+			}
+			mux.Lock()
+			defer mux.Unlock()
+			regions[bucket] = region
+			return nil
+		}
+	}
+	if err := parallel.RunFirstErrWithLimit(_s3BatchConcurrency, fns...); err != nil {
+		return nil, err
+	}
+	return regions, nil
+}
+
+func s3AccessErr(err error, bucket string, operation string) error {
+	if reqErr, ok := errors.Cause(err).(awserr.RequestFailure); ok {
+		switch reqErr.StatusCode() {
+		case http.StatusForbidden:
+			return ErrorS3AccessDenied(bucket, operation)
+		case http.StatusNotFound:
+			return ErrorS3BucketNotFound(bucket)
+		}
+	}
+	if awsErr, ok := errors.Cause(err).(awserr.Error); ok {
+		switch awsErr.Code() {
+		// "RequestError" is the code of the SDK's errors for requests which could not be sent
+		case "RequestError", request.ErrCodeResponseTimeout, request.CanceledErrorCode:
+			return ErrorS3Unreachable(err)
+		}
+	}
+	return errors.Wrap(err, bucket)
+}
+
+func isRetryableErr(err error) bool {
+	err = errors.Cause(err)
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		switch {
+		case reqErr.StatusCode() == http.StatusForbidden, reqErr.StatusCode() == http.StatusNotFound:
+			return false
+		case reqErr.StatusCode() >= http.StatusInternalServerError:
+			return true
+		}
 	}
-	return region, nil
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
 }
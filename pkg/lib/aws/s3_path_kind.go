@@ -0,0 +1,54 @@
+/*
+Copyright 2019 Cortex Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+type S3PathKind int
+
+const (
+	NotFoundS3PathKind S3PathKind = iota
+	FileS3PathKind
+	DirS3PathKind
+)
+
+var s3PathKinds = []string{
+	"not_found",
+	"file",
+	"dir",
+}
+
+func (t S3PathKind) String() string {
+	return s3PathKinds[t]
+}
+
+// MarshalText satisfies TextMarshaler
+func (t S3PathKind) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText satisfies TextUnmarshaler
+func (t *S3PathKind) UnmarshalText(text []byte) error {
+	enum := string(text)
+	for i := 0; i < len(s3PathKinds); i++ {
+		if enum == s3PathKinds[i] {
+			*t = S3PathKind(i)
+			return nil
+		}
+	}
+
+	*t = NotFoundS3PathKind
+	return nil
+}
@@ -0,0 +1,3939 @@
+/*
+Copyright 2019 Cortex Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexlabs/cortex/pkg/lib/errors"
+	"github.com/cortexlabs/cortex/pkg/lib/files"
+	"github.com/cortexlabs/cortex/pkg/lib/msgpack"
+)
+
+const _testBucket = "cortex-test"
+
+type fakeS3Object struct {
+	data            []byte
+	etag            string
+	lastModified    time.Time
+	contentType     string
+	contentEncoding string
+	cacheControl    string
+	metadata        map[string]*string
+	tags            []*s3.Tag
+	storageClass    string
+
+	websiteRedirectLocation string
+}
+
+type fakeS3Call struct {
+	operation string
+	params    interface{}
+}
+
+type fakeMultipartUpload struct {
+	key       string
+	initiated time.Time
+	parts     map[int64][]byte
+}
+
+// fakeS3 is an in-memory S3 which replaces the SDK's send handlers, so requests never leave the process
+type fakeS3 struct {
+	mux     sync.Mutex
+	objects map[string]*fakeS3Object
+	calls   []fakeS3Call
+
+	objectLockEnabled bool
+	bucketPolicy      string
+	multipartUploads  map[string]*fakeMultipartUpload // upload ID -> upload
+	// hooks run before the default handling of an operation; returning true skips the default handling
+	hooks map[string]func(r *request.Request) bool
+}
+
+func newFakeS3Client() (*Client, *fakeS3) {
+	fake := &fakeS3{
+		objects:          map[string]*fakeS3Object{},
+		multipartUploads: map[string]*fakeMultipartUpload{},
+		hooks:            map[string]func(r *request.Request) bool{},
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(DefaultS3Region),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+
+	svc := s3.New(sess)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalError.Clear()
+	svc.Handlers.Send.PushBack(fake.handle)
+
+	client := NewWithS3Client(DefaultS3Region, _testBucket, svc)
+
+	return client, fake
+}
+
+func fakeS3Err(code string, statusCode int) error {
+	return awserr.NewRequestFailure(awserr.New(code, code, nil), statusCode, "")
+}
+
+func (f *fakeS3) putObject(key string, data []byte) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.setObject(key, data)
+}
+
+func (f *fakeS3) setObject(key string, data []byte) *fakeS3Object {
+	md5Sum := md5.Sum(data)
+	obj := &fakeS3Object{
+		data:         data,
+		etag:         `"` + hex.EncodeToString(md5Sum[:]) + `"`,
+		lastModified: time.Now(),
+	}
+	f.objects[key] = obj
+	return obj
+}
+
+// multipartUpload returns the upload with the ID, if it's an upload of key (S3 doesn't find uploads by ID alone)
+func (f *fakeS3) multipartUpload(uploadID string, key string) (*fakeMultipartUpload, bool) {
+	upload, ok := f.multipartUploads[uploadID]
+	if !ok || upload.key != key {
+		return nil, false
+	}
+	return upload, true
+}
+
+func (f *fakeS3) object(key string) (*fakeS3Object, bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	obj, ok := f.objects[key]
+	return obj, ok
+}
+
+func (f *fakeS3) lastInput(operation string) interface{} {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if f.calls[i].operation == operation {
+			return f.calls[i].params
+		}
+	}
+	return nil
+}
+
+func (f *fakeS3) numCalls(operation string) int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	count := 0
+	for _, call := range f.calls {
+		if call.operation == operation {
+			count++
+		}
+	}
+	return count
+}
+
+// parseRange parses "bytes=start-end", "bytes=start-" or "bytes=-suffixLength" into an inclusive range
+func parseRange(rangeHeader string, size int64) (int64, int64, bool) {
+	spec := strings.TrimPrefix(rangeHeader, "bytes=")
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	var start, end int64
+	var err error
+	if parts[0] == "" {
+		suffixLength, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || suffixLength <= 0 {
+			return 0, 0, false
+		}
+		if suffixLength > size {
+			suffixLength = size
+		}
+		return size - suffixLength, size - 1, size > 0
+	}
+
+	if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	end = size - 1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, false
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// S3 returns metadata keys in canonical header form
+func canonicalMetadata(metadata map[string]*string) map[string]*string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	canonical := make(map[string]*string, len(metadata))
+	for k, v := range metadata {
+		canonical[http.CanonicalHeaderKey(k)] = v
+	}
+	return canonical
+}
+
+func optionalString(str string) *string {
+	if str == "" {
+		return nil
+	}
+	return aws.String(str)
+}
+
+func (f *fakeS3) handle(r *request.Request) {
+	f.mux.Lock()
+	f.calls = append(f.calls, fakeS3Call{operation: r.Operation.Name, params: r.Params})
+	hook := f.hooks[r.Operation.Name]
+	f.mux.Unlock()
+
+	r.HTTPResponse = &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(nil)),
+	}
+
+	if hook != nil && hook(r) {
+		return
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	switch input := r.Params.(type) {
+	case *s3.HeadObjectInput:
+		obj, ok := f.objects[*input.Key]
+		if !ok {
+			r.Error = fakeS3Err("NotFound", http.StatusNotFound)
+			return
+		}
+		if input.IfModifiedSince != nil && !obj.lastModified.After(*input.IfModifiedSince) {
+			r.Error = fakeS3Err("NotModified", http.StatusNotModified)
+			return
+		}
+		output := r.Data.(*s3.HeadObjectOutput)
+		output.ContentLength = aws.Int64(int64(len(obj.data)))
+		output.ETag = aws.String(obj.etag)
+		output.LastModified = aws.Time(obj.lastModified)
+		output.ContentType = optionalString(obj.contentType)
+		output.ContentEncoding = optionalString(obj.contentEncoding)
+		output.CacheControl = optionalString(obj.cacheControl)
+		output.WebsiteRedirectLocation = optionalString(obj.websiteRedirectLocation)
+		output.Metadata = obj.metadata
+
+	case *s3.GetObjectInput:
+		obj, ok := f.objects[*input.Key]
+		if !ok {
+			r.Error = fakeS3Err("NoSuchKey", http.StatusNotFound)
+			return
+		}
+		output := r.Data.(*s3.GetObjectOutput)
+		data := obj.data
+		if input.Range != nil {
+			start, end, ok := parseRange(*input.Range, int64(len(obj.data)))
+			if !ok {
+				r.Error = fakeS3Err("InvalidRange", http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			data = obj.data[start : end+1]
+			output.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(obj.data)))
+		}
+		output.Body = ioutil.NopCloser(bytes.NewReader(data))
+		output.ContentLength = aws.Int64(int64(len(data)))
+		output.ETag = aws.String(obj.etag)
+		output.LastModified = aws.Time(obj.lastModified)
+		output.ContentType = optionalString(obj.contentType)
+		output.ContentEncoding = optionalString(obj.contentEncoding)
+		output.Metadata = obj.metadata
+		output.WebsiteRedirectLocation = optionalString(obj.websiteRedirectLocation)
+
+	case *s3.PutObjectInput:
+		data, err := ioutil.ReadAll(input.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		obj := f.setObject(*input.Key, data)
+		obj.contentType = aws.StringValue(input.ContentType)
+		obj.contentEncoding = aws.StringValue(input.ContentEncoding)
+		obj.cacheControl = aws.StringValue(input.CacheControl)
+		obj.metadata = canonicalMetadata(input.Metadata)
+		obj.websiteRedirectLocation = aws.StringValue(input.WebsiteRedirectLocation)
+		r.Data.(*s3.PutObjectOutput).ETag = aws.String(obj.etag)
+
+	case *s3.GetObjectLockConfigurationInput:
+		if !f.objectLockEnabled {
+			r.Error = fakeS3Err("ObjectLockConfigurationNotFoundError", http.StatusNotFound)
+			return
+		}
+		r.Data.(*s3.GetObjectLockConfigurationOutput).ObjectLockConfiguration = &s3.ObjectLockConfiguration{
+			ObjectLockEnabled: aws.String(s3.ObjectLockEnabledEnabled),
+		}
+
+	case *s3.CopyObjectInput:
+		copySource, err := url.PathUnescape(*input.CopySource)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		src, ok := f.objects[strings.TrimPrefix(copySource, *input.Bucket+"/")]
+		if !ok {
+			r.Error = fakeS3Err("NoSuchKey", http.StatusNotFound)
+			return
+		}
+		if input.CopySourceIfMatch != nil && *input.CopySourceIfMatch != src.etag {
+			r.Error = fakeS3Err("PreconditionFailed", http.StatusPreconditionFailed)
+			return
+		}
+		obj := f.setObject(*input.Key, src.data)
+		obj.contentType = src.contentType
+		obj.contentEncoding = src.contentEncoding
+		obj.cacheControl = src.cacheControl
+		obj.websiteRedirectLocation = src.websiteRedirectLocation
+		obj.metadata = src.metadata
+		obj.storageClass = aws.StringValue(input.StorageClass)
+		// like S3, the object's headers are all taken from the request when they're replaced
+		if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+			obj.contentType = aws.StringValue(input.ContentType)
+			obj.contentEncoding = aws.StringValue(input.ContentEncoding)
+			obj.cacheControl = aws.StringValue(input.CacheControl)
+			obj.websiteRedirectLocation = aws.StringValue(input.WebsiteRedirectLocation)
+			obj.metadata = canonicalMetadata(input.Metadata)
+		}
+		r.Data.(*s3.CopyObjectOutput).CopyObjectResult = &s3.CopyObjectResult{
+			ETag:         aws.String(obj.etag),
+			LastModified: aws.Time(obj.lastModified),
+		}
+
+	case *s3.DeleteObjectInput:
+		delete(f.objects, *input.Key)
+
+	case *s3.DeleteObjectsInput:
+		output := r.Data.(*s3.DeleteObjectsOutput)
+		for _, obj := range input.Delete.Objects {
+			delete(f.objects, *obj.Key)
+			output.Deleted = append(output.Deleted, &s3.DeletedObject{Key: obj.Key})
+		}
+
+	case *s3.ListObjectsV2Input:
+		f.listObjectsV2(input, r.Data.(*s3.ListObjectsV2Output))
+
+	case *s3.GetObjectTaggingInput:
+		obj, ok := f.objects[*input.Key]
+		if !ok {
+			r.Error = fakeS3Err("NoSuchKey", http.StatusNotFound)
+			return
+		}
+		r.Data.(*s3.GetObjectTaggingOutput).TagSet = obj.tags
+
+	case *s3.PutObjectTaggingInput:
+		obj, ok := f.objects[*input.Key]
+		if !ok {
+			r.Error = fakeS3Err("NoSuchKey", http.StatusNotFound)
+			return
+		}
+		obj.tags = input.Tagging.TagSet
+
+	case *s3.CreateBucketInput, *s3.PutBucketEncryptionInput:
+
+	case *s3.GetBucketPolicyInput:
+		if f.bucketPolicy == "" {
+			r.Error = fakeS3Err("NoSuchBucketPolicy", http.StatusNotFound)
+			return
+		}
+		r.Data.(*s3.GetBucketPolicyOutput).Policy = aws.String(f.bucketPolicy)
+
+	case *s3.PutBucketPolicyInput:
+		f.bucketPolicy = *input.Policy
+
+	case *s3.DeleteBucketPolicyInput:
+		f.bucketPolicy = ""
+
+	case *s3.HeadBucketInput:
+		if *input.Bucket != _testBucket {
+			r.Error = fakeS3Err("NotFound", http.StatusNotFound)
+		}
+
+	case *s3.CreateMultipartUploadInput:
+		uploadID := fmt.Sprintf("upload-%d", len(f.calls))
+		f.multipartUploads[uploadID] = &fakeMultipartUpload{key: *input.Key, initiated: time.Now(), parts: map[int64][]byte{}}
+		r.Data.(*s3.CreateMultipartUploadOutput).UploadId = aws.String(uploadID)
+
+	case *s3.UploadPartInput:
+		upload, ok := f.multipartUpload(*input.UploadId, *input.Key)
+		if !ok {
+			r.Error = fakeS3Err("NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		data, err := ioutil.ReadAll(input.Body)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		upload.parts[*input.PartNumber] = data
+		md5Sum := md5.Sum(data)
+		r.Data.(*s3.UploadPartOutput).ETag = aws.String(`"` + hex.EncodeToString(md5Sum[:]) + `"`)
+
+	case *s3.UploadPartCopyInput:
+		upload, ok := f.multipartUpload(*input.UploadId, *input.Key)
+		if !ok {
+			r.Error = fakeS3Err("NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		copySource, err := url.PathUnescape(*input.CopySource)
+		if err != nil {
+			r.Error = err
+			return
+		}
+		src, ok := f.objects[strings.TrimPrefix(copySource, *input.Bucket+"/")]
+		if !ok {
+			r.Error = fakeS3Err("NoSuchKey", http.StatusNotFound)
+			return
+		}
+		data := src.data
+		if input.CopySourceRange != nil {
+			var start, end int
+			if _, err := fmt.Sscanf(*input.CopySourceRange, "bytes=%d-%d", &start, &end); err != nil || end >= len(data) {
+				r.Error = fakeS3Err("InvalidArgument", http.StatusBadRequest)
+				return
+			}
+			data = data[start : end+1]
+		}
+		upload.parts[*input.PartNumber] = data
+		r.Data.(*s3.UploadPartCopyOutput).CopyPartResult = &s3.CopyPartResult{ETag: aws.String(src.etag)}
+
+	case *s3.CompleteMultipartUploadInput:
+		upload, ok := f.multipartUpload(*input.UploadId, *input.Key)
+		if !ok {
+			r.Error = fakeS3Err("NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		var data []byte
+		for _, part := range input.MultipartUpload.Parts {
+			data = append(data, upload.parts[*part.PartNumber]...)
+		}
+		delete(f.multipartUploads, *input.UploadId)
+		f.setObject(*input.Key, data)
+
+	case *s3.AbortMultipartUploadInput:
+		if _, ok := f.multipartUpload(*input.UploadId, *input.Key); !ok {
+			r.Error = fakeS3Err("NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		delete(f.multipartUploads, *input.UploadId)
+
+	case *s3.ListMultipartUploadsInput:
+		output := r.Data.(*s3.ListMultipartUploadsOutput)
+		output.IsTruncated = aws.Bool(false)
+		for uploadID, upload := range f.multipartUploads {
+			if strings.HasPrefix(upload.key, aws.StringValue(input.Prefix)) {
+				output.Uploads = append(output.Uploads, &s3.MultipartUpload{
+					Key:       aws.String(upload.key),
+					UploadId:  aws.String(uploadID),
+					Initiated: aws.Time(upload.initiated),
+				})
+			}
+		}
+
+	case *s3.ListPartsInput:
+		upload, ok := f.multipartUpload(*input.UploadId, *input.Key)
+		if !ok {
+			r.Error = fakeS3Err("NoSuchUpload", http.StatusNotFound)
+			return
+		}
+		output := r.Data.(*s3.ListPartsOutput)
+		output.IsTruncated = aws.Bool(false)
+		for partNumber, data := range upload.parts {
+			md5Sum := md5.Sum(data)
+			output.Parts = append(output.Parts, &s3.Part{
+				PartNumber: aws.Int64(partNumber),
+				ETag:       aws.String(`"` + hex.EncodeToString(md5Sum[:]) + `"`),
+				Size:       aws.Int64(int64(len(data))),
+			})
+		}
+
+	default:
+		r.Error = fakeS3Err("NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeS3) sortedKeys() []string {
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// continuation tokens are the last key (or common prefix) returned in the previous page
+func (f *fakeS3) listObjectsV2(input *s3.ListObjectsV2Input, output *s3.ListObjectsV2Output) {
+	prefix := aws.StringValue(input.Prefix)
+	delimiter := aws.StringValue(input.Delimiter)
+	startAfter := aws.StringValue(input.StartAfter)
+	if input.ContinuationToken != nil {
+		startAfter = *input.ContinuationToken
+	}
+	maxKeys := 1000
+	if input.MaxKeys != nil {
+		maxKeys = int(*input.MaxKeys)
+	}
+
+	seenPrefixes := map[string]bool{}
+	last := ""
+	for _, key := range f.sortedKeys() {
+		if !strings.HasPrefix(key, prefix) || key <= startAfter {
+			continue
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix := key[:len(prefix)+i+len(delimiter)]
+				if seenPrefixes[commonPrefix] || commonPrefix <= startAfter {
+					continue
+				}
+				if len(output.Contents)+len(output.CommonPrefixes) >= maxKeys {
+					output.IsTruncated = aws.Bool(true)
+					output.NextContinuationToken = aws.String(last)
+					break
+				}
+				seenPrefixes[commonPrefix] = true
+				output.CommonPrefixes = append(output.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(commonPrefix)})
+				last = commonPrefix
+				continue
+			}
+		}
+
+		if len(output.Contents)+len(output.CommonPrefixes) >= maxKeys {
+			output.IsTruncated = aws.Bool(true)
+			output.NextContinuationToken = aws.String(last)
+			break
+		}
+		obj := f.objects[key]
+		output.Contents = append(output.Contents, &s3.Object{
+			Key:          aws.String(key),
+			ETag:         aws.String(obj.etag),
+			Size:         aws.Int64(int64(len(obj.data))),
+			LastModified: aws.Time(obj.lastModified),
+			StorageClass: optionalString(obj.storageClass),
+		})
+		last = key
+	}
+
+	if output.IsTruncated == nil {
+		output.IsTruncated = aws.Bool(false)
+	}
+	output.KeyCount = aws.Int64(int64(len(output.Contents) + len(output.CommonPrefixes)))
+}
+
+func TestIsS3FileEventually(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	polls := 0
+	fake.hooks["HeadObject"] = func(r *request.Request) bool {
+		polls++
+		if polls == 3 {
+			fake.putObject("new-object", []byte("data"))
+		}
+		return false
+	}
+
+	exists, err := client.IsS3FileEventually("new-object", 10*time.Second)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, 3, polls)
+
+	delete(fake.hooks, "HeadObject")
+
+	exists, err = client.IsS3FileEventually("missing-object", 250*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	fake.hooks["HeadObject"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+
+	_, err = client.IsS3FileEventually("forbidden-object", 10*time.Second)
+	require.True(t, CheckErrCode(err, "AccessDenied"))
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(obj)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, objPtr interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(objPtr)
+}
+
+func (gobCodec) ContentType() string {
+	return "application/x-gob"
+}
+
+type codecTestObj struct {
+	Name  string
+	Count int
+}
+
+func TestReadAutoFromS3(t *testing.T) {
+	client, _ := newFakeS3Client()
+	expected := codecTestObj{Name: "test", Count: 3}
+
+	require.NoError(t, client.UploadJSONToS3(expected, "obj.json"))
+	require.NoError(t, client.UploadMsgpackToS3(expected, "obj.msgpack"))
+	require.NoError(t, client.UploadStringToS3("name: test\ncount: 3\n", "obj.yaml"))
+	require.NoError(t, client.UploadStringToS3("name: test\ncount: 3\n", "dir.v2/OBJ.YML"))
+
+	for _, key := range []string{"obj.json", "obj.msgpack", "obj.yaml", "dir.v2/OBJ.YML"} {
+		var obj codecTestObj
+		require.NoError(t, client.ReadAutoFromS3(&obj, key), key)
+		require.Equal(t, expected, obj, key)
+	}
+
+	require.NoError(t, client.UploadStringToS3("name,count", "obj.csv"))
+	var obj codecTestObj
+	err := client.ReadAutoFromS3(&obj, "obj.csv")
+	require.Equal(t, ErrUnsupportedS3ObjectExtension, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), `"csv"`)
+
+	err = client.ReadAutoFromS3(&obj, "dir.v2/obj")
+	require.Equal(t, ErrUnsupportedS3ObjectExtension, errors.Cause(err).(Error).Kind)
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	RegisterCodec("gob", gobCodec{})
+	codec, ok := GetCodec("gob")
+	require.True(t, ok)
+
+	obj := codecTestObj{Name: "test", Count: 3}
+	require.NoError(t, client.WriteObject(obj, "obj.gob", codec))
+
+	stored, ok := fake.object("obj.gob")
+	require.True(t, ok)
+	require.Equal(t, "application/x-gob", stored.contentType)
+
+	var readObj codecTestObj
+	require.NoError(t, client.ReadObject(&readObj, "obj.gob", codec))
+	require.Equal(t, obj, readObj)
+
+	require.NoError(t, client.UploadJSONToS3(obj, "obj.json"))
+	stored, _ = fake.object("obj.json")
+	require.Equal(t, "application/json", stored.contentType)
+
+	readObj = codecTestObj{}
+	require.NoError(t, client.ReadJSONFromS3(&readObj, "obj.json"))
+	require.Equal(t, obj, readObj)
+}
+
+func TestOperationTimeout(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("slow-object", []byte("data"))
+
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		select {
+		case <-r.Context().Done():
+			r.Error = awserr.New(request.CanceledErrorCode, "request context canceled", r.Context().Err())
+			return true
+		case <-time.After(200 * time.Millisecond):
+			return false
+		}
+	}
+
+	client.OperationTimeout = 20 * time.Millisecond
+	_, err := client.ReadBytesFromS3("slow-object")
+	require.Error(t, err)
+	awsErr, ok := errors.Cause(err).(awserr.Error)
+	require.True(t, ok)
+	require.Equal(t, request.CanceledErrorCode, awsErr.Code())
+	require.Equal(t, context.DeadlineExceeded, awsErr.OrigErr())
+
+	data, err := client.WithOperationTimeout(5 * time.Second).ReadBytesFromS3("slow-object")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+	require.Equal(t, 20*time.Millisecond, client.OperationTimeout)
+}
+
+func TestOperationTimeoutPerCall(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.OperationTimeout = 100 * time.Millisecond
+	client.S3Concurrency = 1
+
+	callDelay := 40 * time.Millisecond
+	slowCall := func(r *request.Request) bool {
+		select {
+		case <-r.Context().Done():
+			r.Error = awserr.New(request.CanceledErrorCode, "request context canceled", r.Context().Err())
+			return true
+		case <-time.After(callDelay):
+			return false
+		}
+	}
+	fake.hooks["UploadPart"] = slowCall
+	fake.hooks["GetObject"] = slowCall
+
+	// each call is within the timeout, but the transfers (of 4 sequential parts) are not
+	data := []byte("aaaabbbbccccdd")
+	options := MultipartUploadOptions{PartSize: 4, Concurrency: 1}
+	require.NoError(t, client.UploadMultipartToS3(bytes.NewReader(data), "object", options))
+	require.Equal(t, 4, fake.numCalls("UploadPart"))
+
+	defer func(size int64) { _largeDownloadPartSize = size }(_largeDownloadPartSize)
+	_largeDownloadPartSize = 4
+	buf := aws.NewWriteAtBuffer(nil)
+	_, err := client.DownloadLargeToWriterAt("object", buf)
+	require.NoError(t, err)
+	require.Equal(t, data, buf.Bytes())
+	require.Equal(t, 4, fake.numCalls("GetObject"))
+
+	callDelay = 200 * time.Millisecond
+	err = client.UploadMultipartToS3(bytes.NewReader(data), "object", options)
+	require.True(t, CheckErrCode(err, request.CanceledErrorCode))
+	_, err = client.DownloadLargeToWriterAt("object", aws.NewWriteAtBuffer(nil))
+	require.True(t, CheckErrCode(err, request.CanceledErrorCode))
+}
+
+func TestIsS3Prefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("models/a/1.txt", []byte("1"))
+
+	exists, err := client.IsS3Prefix("models/")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	exists, err = client.IsS3Prefix("models/", "missing/")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	// first page has no contents, but has a common prefix
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		output := r.Data.(*s3.ListObjectsV2Output)
+		output.KeyCount = aws.Int64(0)
+		output.IsTruncated = aws.Bool(false)
+		output.CommonPrefixes = []*s3.CommonPrefix{{Prefix: aws.String("dir/sub/")}}
+		return true
+	}
+
+	exists, err = client.IsS3Prefix("dir/")
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// first page is empty but truncated, second page has contents
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		input := r.Params.(*s3.ListObjectsV2Input)
+		output := r.Data.(*s3.ListObjectsV2Output)
+		output.KeyCount = aws.Int64(0)
+		if input.ContinuationToken == nil {
+			output.IsTruncated = aws.Bool(true)
+			output.NextContinuationToken = aws.String("token")
+			return true
+		}
+		output.IsTruncated = aws.Bool(false)
+		output.Contents = []*s3.Object{{Key: aws.String("paged/1.txt")}}
+		return true
+	}
+
+	exists, err = client.IsS3Prefix("paged/")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestUploadJSONLinesToS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	var records []interface{}
+	for i := 0; i < 100; i++ {
+		records = append(records, codecTestObj{Name: fmt.Sprintf("record-%d", i), Count: i})
+	}
+
+	require.NoError(t, client.UploadJSONLinesToS3(records, "records.ndjson"))
+
+	stored, ok := fake.object("records.ndjson")
+	require.True(t, ok)
+	require.Equal(t, "application/x-ndjson", stored.contentType)
+	require.Equal(t, 100, bytes.Count(stored.data, []byte("\n")))
+
+	var readRecords []interface{}
+	err := client.ReadJSONLinesFromS3("records.ndjson", func(line []byte) error {
+		var record codecTestObj
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		readRecords = append(readRecords, record)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, records, readRecords)
+}
+
+func TestStoreContentHash(t *testing.T) {
+	client, fake := newFakeS3Client()
+	data := []byte("model weights")
+
+	require.NoError(t, client.UploadBytesToS3WithOptions(data, "hashed", UploadOptions{StoreContentHash: true}))
+
+	stored, _ := fake.object("hashed")
+	require.Equal(t, ContentHash(data), aws.StringValue(stored.metadata["Content-Sha256"]))
+
+	hash, err := client.GetS3ContentHash("hashed")
+	require.NoError(t, err)
+	require.Equal(t, ContentHash(data), hash)
+
+	require.NoError(t, client.UploadBytesToS3(data, "unhashed"))
+	hash, err = client.GetS3ContentHash("unhashed")
+	require.NoError(t, err)
+	require.Equal(t, "", hash)
+}
+
+func TestObjectLockRetention(t *testing.T) {
+	client, fake := newFakeS3Client()
+	retainUntil := time.Now().Add(365 * 24 * time.Hour)
+	options := UploadOptions{
+		ObjectLockMode:            s3.ObjectLockModeCompliance,
+		ObjectLockRetainUntilDate: &retainUntil,
+	}
+
+	err := client.UploadBytesToS3WithOptions([]byte("data"), "locked", options)
+	require.Error(t, err)
+	require.Equal(t, ErrObjectLockNotEnabled, errors.Cause(err).(Error).Kind)
+	require.Equal(t, 0, fake.numCalls("PutObject"))
+
+	fake.objectLockEnabled = true
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("data"), "locked", options))
+
+	input := fake.lastInput("PutObject").(*s3.PutObjectInput)
+	require.Equal(t, s3.ObjectLockModeCompliance, *input.ObjectLockMode)
+	require.Equal(t, retainUntil, *input.ObjectLockRetainUntilDate)
+
+	err = client.UploadBytesToS3WithOptions([]byte("data"), "locked", UploadOptions{ObjectLockMode: "FOREVER", ObjectLockRetainUntilDate: &retainUntil})
+	require.Equal(t, ErrInvalidObjectLockRetention, errors.Cause(err).(Error).Kind)
+}
+
+func TestDeleteS3PathByPrefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("apps/a/1.json", []byte("1"))
+	fake.putObject("apps/a/2.json", []byte("2"))
+	fake.putObject("apps/b/1.json", []byte("1"))
+
+	require.NoError(t, client.DeleteS3PathByPrefix("s3://"+_testBucket+"/apps/a/", false))
+
+	require.Equal(t, []string{"apps/b/1.json"}, fake.sortedKeys())
+
+	err := client.DeleteS3PathByPrefix("s3://other-bucket/apps/b/", false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not match client bucket")
+	require.Equal(t, []string{"apps/b/1.json"}, fake.sortedKeys())
+}
+
+func TestListS3Level(t *testing.T) {
+	client, fake := newFakeS3Client()
+	for _, key := range []string{
+		"root/1.txt",
+		"root/2.txt",
+		"root/a/1.txt",
+		"root/a/b/1.txt",
+		"root/c/1.txt",
+		"rootless.txt",
+	} {
+		fake.putObject(key, []byte(key))
+	}
+
+	// force pagination
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		r.Params.(*s3.ListObjectsV2Input).MaxKeys = aws.Int64(1)
+		return false
+	}
+
+	files, dirs, err := client.ListS3Level("root/")
+	require.NoError(t, err)
+
+	var fileKeys []string
+	for _, file := range files {
+		fileKeys = append(fileKeys, *file.Key)
+	}
+	require.Equal(t, []string{"root/1.txt", "root/2.txt"}, fileKeys)
+	require.Equal(t, []string{"root/a/", "root/c/"}, dirs)
+	require.Equal(t, 4, fake.numCalls("ListObjectsV2"))
+}
+
+func TestRegionRedirect(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("object", []byte("data"))
+
+	var regions []string
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		region := aws.StringValue(r.Config.Region)
+		regions = append(regions, region)
+		if region != "eu-central-1" {
+			r.Error = awserr.NewRequestFailure(awserr.New("BucketRegionError",
+				fmt.Sprintf("incorrect region, the bucket is not in '%s' region at endpoint '', bucket is in 'eu-central-1' region", region),
+				nil), http.StatusMovedPermanently, "")
+			return true
+		}
+		return false
+	}
+
+	data, err := client.ReadBytesFromS3("object")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+	require.Equal(t, []string{DefaultS3Region, "eu-central-1"}, regions)
+	require.Equal(t, "eu-central-1", client.S3PathWithRegion("object").Region)
+
+	_, err = client.ReadBytesFromS3("object")
+	require.NoError(t, err)
+	require.Equal(t, []string{DefaultS3Region, "eu-central-1", "eu-central-1"}, regions)
+
+	// copies of the client share the redirect for the same bucket
+	_, err = client.WithOperationTimeout(time.Minute).ReadBytesFromS3("object")
+	require.NoError(t, err)
+	require.Equal(t, []string{DefaultS3Region, "eu-central-1", "eu-central-1", "eu-central-1"}, regions)
+	require.Equal(t, "eu-central-1", client.WithBucket(client.Bucket).S3PathWithRegion("object").Region)
+	require.Equal(t, DefaultS3Region, client.WithBucket("other-bucket").S3PathWithRegion("object").Region)
+}
+
+func TestRegionRedirectAllCalls(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("object", []byte("data"))
+
+	redirects := 0
+	redirectToEU := func(r *request.Request) bool {
+		region := aws.StringValue(r.Config.Region)
+		if region != "eu-central-1" {
+			redirects++
+			r.Error = awserr.NewRequestFailure(awserr.New("BucketRegionError",
+				fmt.Sprintf("incorrect region, the bucket is not in '%s' region at endpoint '', bucket is in 'eu-central-1' region", region),
+				nil), http.StatusMovedPermanently, "")
+			return true
+		}
+		require.Equal(t, _testBucket+".s3.eu-central-1.amazonaws.com", r.HTTPRequest.URL.Host)
+		return false
+	}
+	for _, operation := range []string{"HeadObject", "ListObjectsV2", "CopyObject", "DeleteObject", "PutObject", "CreateMultipartUpload", "UploadPart", "CompleteMultipartUpload"} {
+		fake.hooks[operation] = redirectToEU
+	}
+
+	// a streamed body can't be re-sent, but the next call goes to the bucket's region
+	err := client.UploadReaderWithLengthToS3(bytes.NewBufferString("data"), 4, "streamed")
+	require.True(t, CheckErrCode(err, "BucketRegionError"))
+	require.Equal(t, 1, redirects)
+	require.NoError(t, client.UploadReaderWithLengthToS3(bytes.NewBufferString("data"), 4, "streamed"))
+
+	_, err = client.GetS3ObjectMetadata("object")
+	require.NoError(t, err)
+	objects, err := client.ListPrefix("", 10)
+	require.NoError(t, err)
+	require.Len(t, objects, 2)
+	require.NoError(t, client.MoveS3File("streamed", "moved", false))
+	require.NoError(t, client.UploadMultipartToS3(bytes.NewReader([]byte("data")), "multipart", MultipartUploadOptions{}))
+	require.Equal(t, 1, redirects)
+
+	// the redirect is cached by the client (and its copies), not by its S3 handle
+	otherClient := NewWithS3Client(DefaultS3Region, _testBucket, client.S3)
+	exists, err := otherClient.IsS3File("moved")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, 2, redirects)
+	_, err = client.WithBucket(_testBucket).GetS3ObjectMetadata("moved")
+	require.NoError(t, err)
+	require.Equal(t, 2, redirects)
+}
+
+func TestRegionRedirectConcurrent(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("object", []byte("data"))
+
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		region := aws.StringValue(r.Config.Region)
+		if region != "eu-central-1" {
+			r.Error = awserr.NewRequestFailure(awserr.New("BucketRegionError",
+				fmt.Sprintf("incorrect region, the bucket is not in '%s' region at endpoint '', bucket is in 'eu-central-1' region", region),
+				nil), http.StatusMovedPermanently, "")
+			return true
+		}
+		return false
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 20)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = client.WithOperationTimeout(time.Minute).ReadBytesFromS3("object")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	require.Equal(t, "eu-central-1", client.S3PathWithRegion("object").Region)
+}
+
+func TestExpectedBucketOwner(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.ExpectedBucketOwner = "111111111111"
+
+	var headers []string
+	fake.hooks["PutObject"] = func(r *request.Request) bool {
+		headers = append(headers, r.HTTPRequest.Header.Get("X-Amz-Expected-Bucket-Owner"))
+		return false
+	}
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		headers = append(headers, r.HTTPRequest.Header.Get("X-Amz-Expected-Bucket-Owner"))
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+
+	require.NoError(t, client.UploadStringToS3("data", "key"))
+
+	_, err := client.ReadStringFromS3("key")
+	require.Error(t, err)
+	require.Equal(t, ErrBucketOwnerMismatch, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "111111111111")
+	require.True(t, CheckErrCode(err, "AccessDenied"))
+	require.Equal(t, "AccessDenied", errors.Cause(err).(Error).OrigErr().(awserr.Error).Code())
+
+	require.Equal(t, []string{"111111111111", "111111111111"}, headers)
+
+	// other 403s aren't attributed to the owner check
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("SignatureDoesNotMatch", http.StatusForbidden)
+		return true
+	}
+	_, err = client.ReadStringFromS3("key")
+	require.True(t, CheckErrCode(err, "SignatureDoesNotMatch"))
+	_, isAWSErr := errors.Cause(err).(Error)
+	require.False(t, isAWSErr)
+}
+
+func TestDownloadLargeToWriterAt(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	data := make([]byte, 3*1024*1024+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	fake.putObject("large", data)
+
+	defaultPartSize := _largeDownloadPartSize
+	_largeDownloadPartSize = 1024 * 1024
+	defer func() { _largeDownloadPartSize = defaultPartSize }()
+
+	buf := aws.NewWriteAtBuffer(nil)
+	numBytes, err := client.DownloadLargeToWriterAt("large", buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), numBytes)
+	require.Equal(t, data, buf.Bytes())
+	require.Equal(t, 4, fake.numCalls("GetObject"))
+}
+
+func TestRenameS3Prefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("v1/a.json", []byte("a"))
+	fake.putObject("v1/sub dir/b.json", []byte("b"))
+	fake.putObject("v10/c.json", []byte("c"))
+
+	require.NoError(t, client.RenameS3Prefix("v1/", "v2/"))
+	require.Equal(t, []string{"v10/c.json", "v2/a.json", "v2/sub dir/b.json"}, fake.sortedKeys())
+
+	obj, _ := fake.object("v2/sub dir/b.json")
+	require.Equal(t, []byte("b"), obj.data)
+
+	// a failed copy leaves the originals in place
+	fake.hooks["CopyObject"] = func(r *request.Request) bool {
+		if strings.HasSuffix(*r.Params.(*s3.CopyObjectInput).Key, "b.json") {
+			r.Error = fakeS3Err("InternalError", http.StatusInternalServerError)
+			return true
+		}
+		return false
+	}
+
+	numDeletes := fake.numCalls("DeleteObjects")
+	require.Error(t, client.RenameS3Prefix("v2/", "v3/"))
+	require.Equal(t, numDeletes, fake.numCalls("DeleteObjects"))
+	exists, err := client.IsS3File("v2/a.json", "v2/sub dir/b.json")
+	require.NoError(t, err)
+	require.True(t, exists)
+}
+
+func TestUploadGzipBytesToS3WithLevel(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	var buf bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&buf, "%d request=%d status=%d latency=%dms\n", i, i*7919%1000, 200+i%3, i*31%977)
+	}
+	data := buf.Bytes()
+
+	require.NoError(t, client.UploadGzipBytesToS3WithLevel(data, "best-speed.gz", gzip.BestSpeed))
+	require.NoError(t, client.UploadGzipBytesToS3WithLevel(data, "best-compression.gz", gzip.BestCompression))
+	require.NoError(t, client.UploadGzipBytesToS3(data, "default.gz"))
+
+	bestSpeed, _ := fake.object("best-speed.gz")
+	bestCompression, _ := fake.object("best-compression.gz")
+	require.Less(t, len(bestCompression.data), len(bestSpeed.data))
+	require.Equal(t, "gzip", bestCompression.contentEncoding)
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(bestCompression.data))
+	require.NoError(t, err)
+	decompressed, err := ioutil.ReadAll(gzipReader)
+	require.NoError(t, err)
+	require.Equal(t, data, decompressed)
+
+	for _, level := range []int{-2, 0, 10} {
+		err := client.UploadGzipBytesToS3WithLevel(data, "invalid.gz", level)
+		require.Equal(t, ErrInvalidGzipCompressionLevel, errors.Cause(err).(Error).Kind)
+	}
+}
+
+// mapS3 implements the subset of s3iface.S3API used below on top of a map; calling any other method panics
+type mapS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+}
+
+func (m *mapS3) HeadObjectWithContext(_ aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	data, ok := m.objects[*input.Key]
+	if !ok {
+		return nil, fakeS3Err("NotFound", http.StatusNotFound)
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+func (m *mapS3) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	data, ok := m.objects[*input.Key]
+	if !ok {
+		return nil, fakeS3Err(s3.ErrCodeNoSuchKey, http.StatusNotFound)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (m *mapS3) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[*input.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *mapS3) ListObjectsV2WithContext(_ aws.Context, input *s3.ListObjectsV2Input, _ ...request.Option) (*s3.ListObjectsV2Output, error) {
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for key := range m.objects {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key)})
+		}
+	}
+	return output, nil
+}
+
+func TestValidateBucketName(t *testing.T) {
+	for _, bucket := range []string{"abc", "cortex-test", "my.bucket.1", "123", strings.Repeat("a", 63)} {
+		require.NoError(t, ValidateBucketName(bucket), bucket)
+	}
+
+	for bucket, reason := range map[string]string{
+		"ab":                    "between 3 and 63 characters",
+		strings.Repeat("a", 64): "between 3 and 63 characters",
+		"My-Bucket":             "lowercase letters, numbers, dots, and hyphens",
+		"my_bucket":             "lowercase letters, numbers, dots, and hyphens",
+		"-bucket":               "start and end with a lowercase letter or number",
+		"bucket.":               "start and end with a lowercase letter or number",
+		"my..bucket":            "consecutive dots",
+		"my-.bucket":            "dots cannot be next to hyphens",
+		"192.168.5.4":           "IP address",
+	} {
+		err := ValidateBucketName(bucket)
+		require.Equal(t, ErrInvalidBucketName, errors.Cause(err).(Error).Kind, bucket)
+		require.Contains(t, err.Error(), reason, bucket)
+	}
+
+	_, err := New(DefaultS3Region, "My_Bucket", false)
+	require.Equal(t, ErrInvalidBucketName, errors.Cause(err).(Error).Kind)
+	_, err = NewFromS3Path("s3://My_Bucket/model", false)
+	require.Equal(t, ErrInvalidBucketName, errors.Cause(err).(Error).Kind)
+}
+
+func TestAccessPointARNBucket(t *testing.T) {
+	accessPoint := "arn:aws:s3:us-west-2:123456789012:accesspoint/models"
+	require.True(t, IsS3AccessPointARN(accessPoint))
+	require.False(t, IsS3AccessPointARN("models"))
+	require.NoError(t, ValidateBucketName(accessPoint))
+	require.NoError(t, ValidateBucketName("arn:aws:s3:us-west-2:123456789012:accesspoint:models"))
+
+	for _, bucket := range []string{
+		"arn:aws:sqs:us-west-2:123456789012:accesspoint/models",
+		"arn:aws:s3:us-west-2::accesspoint/models",
+		"arn:aws:s3:us-west-2:123456789012:bucket/models",
+		"arn:aws:s3:us-west-2:123456789012:accesspoint/",
+	} {
+		err := ValidateBucketName(bucket)
+		require.Equal(t, ErrInvalidBucketName, errors.Cause(err).(Error).Kind, bucket)
+	}
+
+	// the bucket region is taken from the ARN, so no request is needed to construct the client
+	client, err := New("us-east-1", accessPoint, false)
+	require.NoError(t, err)
+
+	var hosts []string
+	var copySources []string
+	svc := client.S3.(*s3.S3)
+	svc.Handlers.Sign.Clear()
+	svc.Handlers.Send.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalError.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		hosts = append(hosts, r.HTTPRequest.URL.Host)
+		if output, ok := r.Data.(*s3.GetObjectOutput); ok {
+			output.Body = ioutil.NopCloser(strings.NewReader("data"))
+		}
+		if input, ok := r.Params.(*s3.CopyObjectInput); ok {
+			copySources = append(copySources, *input.CopySource)
+			r.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}
+		}
+	})
+
+	require.NoError(t, client.UploadStringToS3("data", "model.pb"))
+	_, err = client.ReadBytesFromS3("model.pb")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"models-123456789012.s3-accesspoint.us-west-2.amazonaws.com",
+		"models-123456789012.s3-accesspoint.us-west-2.amazonaws.com",
+	}, hosts)
+
+	require.NoError(t, client.CopyS3ToS3("dir/model v1.pb", "model.pb"))
+	require.Equal(t, []string{"arn:aws:s3:us-west-2:123456789012:accesspoint/models/object/dir/model%20v1.pb"}, copySources)
+	require.Equal(t, "arn:aws:s3:us-west-2:123456789012:accesspoint/models/object/model.pb",
+		client.WithBucket("arn:aws:s3:us-west-2:123456789012:accesspoint:models").copySource("model.pb"))
+
+	// access point ARNs (in either form) round-trip through s3 paths
+	for _, bucket := range []string{accessPoint, "arn:aws:s3:us-west-2:123456789012:accesspoint:models"} {
+		s3Path := client.WithBucket(bucket).S3Path("dir/model.pb")
+		require.True(t, IsValidS3Path(s3Path))
+		pathBucket, key, err := SplitS3Path(s3Path)
+		require.NoError(t, err)
+		require.Equal(t, bucket, pathBucket)
+		require.Equal(t, "dir/model.pb", key)
+
+		client, err := NewFromS3Path(s3Path, false)
+		require.NoError(t, err)
+		require.Equal(t, bucket, client.Bucket)
+		require.Equal(t, "us-west-2", client.Region)
+	}
+	require.False(t, IsValidS3Path("s3://"+accessPoint))
+	require.False(t, IsValidS3Path("s3://"+accessPoint+"/"))
+}
+
+func TestWithBucket(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.ServerSideEncryption = ServerSideEncryptionNone
+
+	otherClient := client.WithBucket("other-bucket")
+	require.Equal(t, "other-bucket", otherClient.Bucket)
+	require.Equal(t, _testBucket, client.Bucket)
+	require.True(t, client.S3 == otherClient.S3)
+	require.Equal(t, ServerSideEncryptionNone, otherClient.ServerSideEncryption)
+
+	require.NoError(t, otherClient.UploadBytesToS3([]byte("data"), "file"))
+	require.Equal(t, "other-bucket", *fake.lastInput("PutObject").(*s3.PutObjectInput).Bucket)
+}
+
+func TestNewWithS3Client(t *testing.T) {
+	fake := &mapS3{objects: map[string][]byte{"dir/existing": []byte("existing")}}
+	client := NewWithS3Client(DefaultS3Region, _testBucket, fake)
+
+	require.NoError(t, client.UploadBytesToS3([]byte("uploaded"), "dir/uploaded"))
+	require.Equal(t, []byte("uploaded"), fake.objects["dir/uploaded"])
+
+	for _, tc := range []struct {
+		key      string
+		isFile   bool
+		isPrefix bool
+		data     []byte
+	}{
+		{key: "dir/existing", isFile: true, isPrefix: true, data: []byte("existing")},
+		{key: "dir/uploaded", isFile: true, isPrefix: true, data: []byte("uploaded")},
+		{key: "dir/", isFile: false, isPrefix: true},
+		{key: "missing", isFile: false, isPrefix: false},
+	} {
+		isFile, err := client.IsS3File(tc.key)
+		require.NoError(t, err)
+		require.Equal(t, tc.isFile, isFile, tc.key)
+
+		isPrefix, err := client.IsS3Prefix(tc.key)
+		require.NoError(t, err)
+		require.Equal(t, tc.isPrefix, isPrefix, tc.key)
+
+		data, err := client.ReadBytesFromS3(tc.key)
+		if tc.isFile {
+			require.NoError(t, err)
+			require.Equal(t, tc.data, data)
+		} else {
+			require.True(t, IsNoSuchKeyErr(err), tc.key)
+		}
+	}
+}
+
+func TestReadS3ObjectHead(t *testing.T) {
+	client, fake := newFakeS3Client()
+	large := bytes.Repeat([]byte("0123456789"), 1000)
+	fake.putObject("large", large)
+	fake.putObject("small", []byte("tiny"))
+	fake.putObject("empty", []byte{})
+
+	head, err := client.ReadS3ObjectHead("large", 16)
+	require.NoError(t, err)
+	require.Equal(t, large[:16], head)
+	require.Equal(t, "bytes=0-15", aws.StringValue(fake.lastInput("GetObject").(*s3.GetObjectInput).Range))
+
+	head, err = client.ReadS3ObjectHead("small", 16)
+	require.NoError(t, err)
+	require.Equal(t, []byte("tiny"), head)
+
+	head, err = client.ReadS3ObjectHead("empty", 16)
+	require.NoError(t, err)
+	require.Empty(t, head)
+
+	_, err = client.ReadS3ObjectHead("missing", 16)
+	require.True(t, IsNoSuchKeyErr(err))
+}
+
+func TestUploadReaderWithLengthToS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	data := bytes.Repeat([]byte("a"), 1024)
+
+	// ioutil.NopCloser hides the underlying reader's Seek and ReadAt methods
+	require.NoError(t, client.UploadReaderWithLengthToS3(ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), "object"))
+
+	obj, ok := fake.object("object")
+	require.True(t, ok)
+	require.Equal(t, data, obj.data)
+	require.Equal(t, 1, fake.numCalls("PutObject"))
+	require.Equal(t, 0, fake.numCalls("CreateMultipartUpload"))
+	require.Equal(t, int64(len(data)), aws.Int64Value(fake.lastInput("PutObject").(*s3.PutObjectInput).ContentLength))
+
+	// only length bytes are read from a longer reader
+	reader := bytes.NewReader(append([]byte("xx"), data...))
+	_, err := reader.Seek(2, io.SeekStart)
+	require.NoError(t, err)
+	require.NoError(t, client.UploadReaderWithLengthToS3(reader, 512, "seekable"))
+	obj, ok = fake.object("seekable")
+	require.True(t, ok)
+	require.Equal(t, data[:512], obj.data)
+	require.Equal(t, 2, fake.numCalls("PutObject"))
+
+	require.NoError(t, client.UploadReaderWithLengthToS3(ioutil.NopCloser(bytes.NewReader(data)), 10, "unseekable"))
+	obj, ok = fake.object("unseekable")
+	require.True(t, ok)
+	require.Equal(t, data[:10], obj.data)
+
+	err = client.UploadReaderWithLengthToS3(bytes.NewReader(data), int64(len(data))+1, "short")
+	require.Error(t, err)
+	_, ok = fake.object("short")
+	require.False(t, ok)
+	require.Equal(t, 3, fake.numCalls("PutObject"))
+
+	err = client.UploadReaderWithLengthToS3(ioutil.NopCloser(bytes.NewReader(data)), int64(len(data))+1, "short")
+	require.Error(t, err)
+	_, ok = fake.object("short")
+	require.False(t, ok)
+
+	require.Error(t, client.UploadReaderWithLengthToS3(bytes.NewReader(data), -1, "negative"))
+}
+
+func TestGetS3ObjectsMetadata(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("a", []byte("a"))
+	fake.putObject("b", []byte("bb"))
+
+	metadatas, err := client.GetS3ObjectsMetadata("a", "missing", "b")
+	require.NoError(t, err)
+	require.Len(t, metadatas, 2)
+	require.Equal(t, int64(1), metadatas["a"].Size)
+	require.Equal(t, int64(2), metadatas["b"].Size)
+	b, _ := fake.object("b")
+	require.Equal(t, b.etag, metadatas["b"].ETag)
+	require.Equal(t, b.lastModified, metadatas["b"].LastModified)
+	require.NotContains(t, metadatas, "missing")
+
+	fake.hooks["HeadObject"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+	_, err = client.GetS3ObjectsMetadata("a")
+	require.True(t, CheckErrCode(err, "AccessDenied"))
+}
+
+func TestS3KeyJoin(t *testing.T) {
+	for _, tc := range []struct {
+		segments []string
+		expected string
+	}{
+		{segments: []string{}, expected: ""},
+		{segments: []string{"", "/", ""}, expected: ""},
+		{segments: []string{"a", "b", "c"}, expected: "a/b/c"},
+		{segments: []string{"a/", "/b", "c"}, expected: "a/b/c"},
+		{segments: []string{"/a//b/", "", "//c"}, expected: "a/b/c"},
+		{segments: []string{"a", "b/"}, expected: "a/b/"},
+		{segments: []string{"a", "b/", ""}, expected: "a/b/"},
+		{segments: []string{"a/", "b"}, expected: "a/b"},
+		{segments: []string{"a", "./b", "../c"}, expected: "a/./b/../c"},
+		{segments: []string{"a\\b", "c"}, expected: "a\\b/c"},
+	} {
+		require.Equal(t, tc.expected, S3KeyJoin(tc.segments...), "%q", tc.segments)
+	}
+}
+
+func TestS3PathJoin(t *testing.T) {
+	require.Equal(t, "", S3PathJoin())
+	require.Equal(t, "s3://bucket/dir/file", S3PathJoin("s3://bucket/dir/", "/file"))
+	require.Equal(t, "s3://bucket/dir/sub/", S3PathJoin("s3://bucket", "dir", "sub/"))
+	require.Equal(t, "s3://bucket/dir/file", S3PathJoin("s3://bucket//dir", "", "file"))
+
+	paths := []string{"s3://bucket", "key"}
+	S3PathJoin(paths...)
+	require.Equal(t, []string{"s3://bucket", "key"}, paths)
+
+	client, _ := newFakeS3Client()
+	require.Equal(t, "s3://"+_testBucket+"/dir/", client.S3Path("dir/"))
+}
+
+func TestS3PathWithRegion(t *testing.T) {
+	client, _ := newFakeS3Client()
+	pathWithRegion := client.S3PathWithRegion("dir/file")
+	require.Equal(t, "s3://"+_testBucket+"/dir/file", pathWithRegion.Path)
+	require.Equal(t, client.Region, pathWithRegion.Region)
+
+	client = NewWithS3Client("eu-west-1", _testBucket, &mapS3{})
+	require.Equal(t, "eu-west-1", client.S3PathWithRegion("file").Region)
+}
+
+func TestUploadBytesesToS3WithRollback(t *testing.T) {
+	failC := func(r *request.Request) bool {
+		if *r.Params.(*s3.PutObjectInput).Key == "c" {
+			r.Error = fakeS3Err("InternalError", http.StatusInternalServerError)
+			return true
+		}
+		return false
+	}
+
+	client, fake := newFakeS3Client()
+	fake.hooks["PutObject"] = failC
+	err := client.UploadBytesesToS3([]byte("data"), "a", "b", "c", "d")
+	require.True(t, CheckErrCode(err, "InternalError"))
+	require.Equal(t, []string{"a", "b", "d"}, fake.sortedKeys())
+
+	client, fake = newFakeS3Client()
+	fake.hooks["PutObject"] = failC
+	err = client.UploadBytesesToS3WithOptions([]byte("data"), []string{"a", "b", "c", "d"}, UploadOptions{RollbackOnFailure: true})
+	require.True(t, CheckErrCode(err, "InternalError"))
+	require.Empty(t, fake.sortedKeys())
+	require.ElementsMatch(t, []string{"a", "b", "d"}, deletedKeys(fake.lastInput("DeleteObjects").(*s3.DeleteObjectsInput)))
+}
+
+func TestPurgeAllVersions(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	versionIDs := func(objects []*s3.ObjectIdentifier) []string {
+		var ids []string
+		for _, obj := range objects {
+			ids = append(ids, *obj.Key+"@"+*obj.VersionId)
+		}
+		return ids
+	}
+
+	// two pages: the first has two versions of a.txt and a delete marker for b.txt, the second has b.txt's old version
+	fake.hooks["ListObjectVersions"] = func(r *request.Request) bool {
+		input := r.Params.(*s3.ListObjectVersionsInput)
+		output := r.Data.(*s3.ListObjectVersionsOutput)
+		require.Equal(t, "data/", *input.Prefix)
+		if input.KeyMarker == nil {
+			output.Versions = []*s3.ObjectVersion{
+				{Key: aws.String("data/a.txt"), VersionId: aws.String("a2"), IsLatest: aws.Bool(true)},
+				{Key: aws.String("data/a.txt"), VersionId: aws.String("a1")},
+			}
+			output.DeleteMarkers = []*s3.DeleteMarkerEntry{
+				{Key: aws.String("data/b.txt"), VersionId: aws.String("b2"), IsLatest: aws.Bool(true)},
+			}
+			output.IsTruncated = aws.Bool(true)
+			output.NextKeyMarker = aws.String("data/b.txt")
+			output.NextVersionIdMarker = aws.String("b2")
+			return true
+		}
+		require.Equal(t, "b2", *input.VersionIdMarker)
+		output.Versions = []*s3.ObjectVersion{{Key: aws.String("data/b.txt"), VersionId: aws.String("b1")}}
+		output.IsTruncated = aws.Bool(false)
+		return true
+	}
+	var deleted []string
+	fake.hooks["DeleteObjects"] = func(r *request.Request) bool {
+		deleted = append(deleted, versionIDs(r.Params.(*s3.DeleteObjectsInput).Delete.Objects)...)
+		return true
+	}
+
+	require.NoError(t, client.PurgeAllVersions("data/"))
+	require.Equal(t, []string{"data/a.txt@a2", "data/a.txt@a1", "data/b.txt@b2", "data/b.txt@b1"}, deleted)
+
+	fake.hooks["DeleteObjects"] = func(r *request.Request) bool {
+		output := r.Data.(*s3.DeleteObjectsOutput)
+		for _, obj := range r.Params.(*s3.DeleteObjectsInput).Delete.Objects {
+			if *obj.VersionId == "a1" {
+				output.Errors = append(output.Errors, &s3.Error{Key: obj.Key, VersionId: obj.VersionId, Code: aws.String("AccessDenied")})
+			}
+		}
+		return true
+	}
+	err := client.PurgeAllVersions("data/")
+	batchErr, ok := errors.Cause(err).(*S3BatchError)
+	require.True(t, ok)
+	require.Equal(t, []string{"data/a.txt?versionId=a1"}, batchErr.Keys())
+
+	err = client.PurgeAllVersions(" ")
+	require.Equal(t, ErrS3DeleteAllNotAllowed, errors.Cause(err).(Error).Kind)
+}
+
+func TestS3BatchErrors(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.hooks["PutObject"] = func(r *request.Request) bool {
+		switch *r.Params.(*s3.PutObjectInput).Key {
+		case "b":
+			r.Error = fakeS3Err("InternalError", http.StatusInternalServerError)
+		case "d":
+			r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		default:
+			return false
+		}
+		return true
+	}
+
+	err := client.UploadBytesesToS3WithOptions([]byte("data"), []string{"a", "b", "c", "d"}, UploadOptions{CollectAllErrors: true})
+	batchErr, ok := errors.Cause(err).(*S3BatchError)
+	require.True(t, ok)
+	require.Equal(t, []string{"b", "d"}, batchErr.Keys())
+	require.True(t, CheckErrCode(batchErr.Errors["b"], "InternalError"))
+	require.True(t, CheckErrCode(batchErr.Errors["d"], "AccessDenied"))
+	require.Contains(t, err.Error(), "2 S3 operations failed")
+	require.Equal(t, []string{"a", "c"}, fake.sortedKeys())
+
+	// without CollectAllErrors, only the first error is returned
+	err = client.UploadBytesesToS3WithOptions([]byte("data"), []string{"a", "b", "c", "d"}, UploadOptions{})
+	_, ok = errors.Cause(err).(*S3BatchError)
+	require.False(t, ok)
+	require.Error(t, err)
+
+	fake.hooks["DeleteObjects"] = func(r *request.Request) bool {
+		output := r.Data.(*s3.DeleteObjectsOutput)
+		for _, obj := range r.Params.(*s3.DeleteObjectsInput).Delete.Objects {
+			output.Errors = append(output.Errors, &s3.Error{Key: obj.Key, Code: aws.String("AccessDenied"), Message: aws.String("Access Denied")})
+		}
+		return true
+	}
+	err = client.DeleteS3Keys([]string{"a", "c"})
+	batchErr, ok = errors.Cause(err).(*S3BatchError)
+	require.True(t, ok)
+	require.Equal(t, []string{"a", "c"}, batchErr.Keys())
+	require.True(t, CheckErrCode(batchErr.Errors["c"], "AccessDenied"))
+
+	delete(fake.hooks, "DeleteObjects")
+	require.NoError(t, client.DeleteS3Keys([]string{"a", "c"}))
+	require.Empty(t, fake.sortedKeys())
+}
+
+// thriftCompactWriter encodes the Thrift compact protocol, to build Parquet footers for tests
+type thriftCompactWriter struct {
+	buf          bytes.Buffer
+	lastFieldIDs []int16
+}
+
+func (w *thriftCompactWriter) varint(value uint64) {
+	for value >= 0x80 {
+		w.buf.WriteByte(byte(value) | 0x80)
+		value >>= 7
+	}
+	w.buf.WriteByte(byte(value))
+}
+
+func (w *thriftCompactWriter) field(fieldID int16, fieldType byte) {
+	last := &w.lastFieldIDs[len(w.lastFieldIDs)-1]
+	if delta := fieldID - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		w.varint(uint64((int64(fieldID) << 1) ^ (int64(fieldID) >> 63)))
+	}
+	*last = fieldID
+}
+
+func (w *thriftCompactWriter) i32(fieldID int16, value int32) {
+	w.field(fieldID, thriftTypeI32)
+	w.varint(uint64((int64(value) << 1) ^ (int64(value) >> 63)))
+}
+
+func (w *thriftCompactWriter) i64(fieldID int16, value int64) {
+	w.field(fieldID, thriftTypeI64)
+	w.varint(uint64((value << 1) ^ (value >> 63)))
+}
+
+func (w *thriftCompactWriter) binary(fieldID int16, value string) {
+	w.field(fieldID, thriftTypeBinary)
+	w.varint(uint64(len(value)))
+	w.buf.WriteString(value)
+}
+
+func (w *thriftCompactWriter) list(fieldID int16, elemType byte, size int) {
+	w.field(fieldID, thriftTypeList)
+	w.buf.WriteByte(byte(size)<<4 | elemType)
+}
+
+func (w *thriftCompactWriter) structField(fieldID int16) {
+	w.field(fieldID, thriftTypeStruct)
+	w.structBegin()
+}
+
+func (w *thriftCompactWriter) structBegin() {
+	w.lastFieldIDs = append(w.lastFieldIDs, 0)
+}
+
+func (w *thriftCompactWriter) structEnd() {
+	w.buf.WriteByte(thriftTypeStop)
+	w.lastFieldIDs = w.lastFieldIDs[:len(w.lastFieldIDs)-1]
+}
+
+func sampleParquetFile() []byte {
+	type schemaElement struct {
+		name                                          string
+		physicalType, repetition, convertedType, kids int32 // -1 if not set
+		stringLogicalType                             bool
+	}
+	schema := []schemaElement{
+		{"schema", -1, -1, -1, 3, false},
+		{"id", 2, 0, -1, 0, false},
+		{"name", 6, 1, 0, 0, true},
+		{"location", -1, 1, -1, 2, false},
+		{"lat", 5, 0, -1, 0, false},
+		{"lon", 5, 0, -1, 0, false},
+	}
+
+	w := &thriftCompactWriter{}
+	w.structBegin() // FileMetaData
+	w.i32(1, 1)
+	w.list(2, thriftTypeStruct, len(schema))
+	for _, element := range schema {
+		w.structBegin()
+		if element.physicalType >= 0 {
+			w.i32(1, element.physicalType)
+		}
+		if element.repetition >= 0 {
+			w.i32(3, element.repetition)
+		}
+		w.binary(4, element.name)
+		if element.kids > 0 {
+			w.i32(5, element.kids)
+		}
+		if element.convertedType >= 0 {
+			w.i32(6, element.convertedType)
+		}
+		if element.stringLogicalType {
+			w.structField(10) // LogicalType union
+			w.structField(1)  // StringType
+			w.structEnd()
+			w.structEnd()
+		}
+		w.structEnd()
+	}
+	w.i64(3, 0)
+	w.list(4, thriftTypeStruct, 0)
+	w.list(5, thriftTypeStruct, 1)
+	w.structBegin() // KeyValue
+	w.binary(1, "writer.version")
+	w.binary(2, "1.0")
+	w.structEnd()
+	w.binary(6, "cortex test")
+	w.structEnd()
+
+	footer := w.buf.Bytes()
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+	file.WriteString(strings.Repeat("column data ", 100))
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.WriteString("PAR1")
+	return file.Bytes()
+}
+
+func TestReadParquetSchemaFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("data.parquet", sampleParquetFile())
+
+	numGets := fake.numCalls("GetObject")
+	columns, err := client.ReadParquetSchemaFromS3("data.parquet")
+	require.NoError(t, err)
+	require.Equal(t, []ParquetColumn{
+		{Name: "id", Type: "INT64", Repetition: "REQUIRED"},
+		{Name: "name", Type: "BYTE_ARRAY", Repetition: "OPTIONAL", ConvertedType: "UTF8"},
+		{Name: "location.lat", Type: "DOUBLE", Repetition: "REQUIRED"},
+		{Name: "location.lon", Type: "DOUBLE", Repetition: "REQUIRED"},
+	}, columns)
+
+	// only the footer is fetched, with ranged GETs
+	require.Equal(t, numGets+2, fake.numCalls("GetObject"))
+	for _, call := range fake.calls {
+		if call.operation == "GetObject" {
+			require.NotNil(t, call.params.(*s3.GetObjectInput).Range)
+		}
+	}
+
+	fake.putObject("data.csv", []byte("id,name\n1,a\n"))
+	_, err = client.ReadParquetSchemaFromS3("data.csv")
+	require.Equal(t, ErrInvalidParquetFile, errors.Cause(err).(Error).Kind)
+
+	// the footer is cut off
+	data := sampleParquetFile()
+	fake.putObject("truncated.parquet", append(data[:len(data)-40:len(data)-40], data[len(data)-8:]...))
+	_, err = client.ReadParquetSchemaFromS3("truncated.parquet")
+	require.Equal(t, ErrInvalidParquetFile, errors.Cause(err).(Error).Kind)
+
+	// a corrupt footer length which fits in the file isn't allocated and read if it's above the maximum
+	corrupt := make([]byte, _parquetMaxFooterLength+64)
+	copy(corrupt, "PAR1")
+	binary.LittleEndian.PutUint32(corrupt[len(corrupt)-8:], uint32(_parquetMaxFooterLength+1))
+	copy(corrupt[len(corrupt)-4:], "PAR1")
+	fake.putObject("corrupt.parquet", corrupt)
+	numGetObjectCalls := fake.numCalls("GetObject")
+	_, err = client.ReadParquetSchemaFromS3("corrupt.parquet")
+	require.Equal(t, ErrInvalidParquetFile, errors.Cause(err).(Error).Kind)
+	require.Contains(t, errors.Cause(err).Error(), "larger than the maximum")
+	require.Equal(t, numGetObjectCalls+1, fake.numCalls("GetObject"))
+}
+
+func TestMoveS3File(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("staging/model.pb", []byte("new"))
+	fake.putObject("models/model.pb", []byte("old"))
+
+	err := client.MoveS3File("staging/model.pb", "models/model.pb", false)
+	require.Equal(t, ErrAlreadyExists, errors.Cause(err).(Error).Kind)
+	require.Equal(t, []string{"models/model.pb", "staging/model.pb"}, fake.sortedKeys())
+	obj, _ := fake.object("models/model.pb")
+	require.Equal(t, []byte("old"), obj.data)
+
+	require.NoError(t, client.MoveS3File("staging/model.pb", "models/v2/model.pb", false))
+	require.Equal(t, []string{"models/model.pb", "models/v2/model.pb"}, fake.sortedKeys())
+	obj, _ = fake.object("models/v2/model.pb")
+	require.Equal(t, []byte("new"), obj.data)
+
+	require.NoError(t, client.MoveS3File("models/v2/model.pb", "models/model.pb", true))
+	require.Equal(t, []string{"models/model.pb"}, fake.sortedKeys())
+	obj, _ = fake.object("models/model.pb")
+	require.Equal(t, []byte("new"), obj.data)
+}
+
+func TestPublishS3ObjectSet(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("release/a.json", []byte("old a"))
+
+	items := map[string][]byte{"a.json": []byte("new a"), "b.json": []byte("new b"), "c/d.json": []byte("new d")}
+	fake.hooks["PutObject"] = func(r *request.Request) bool {
+		if *r.Params.(*s3.PutObjectInput).Key == "staging/b.json" {
+			r.Error = fakeS3Err("InternalError", http.StatusInternalServerError)
+			return true
+		}
+		return false
+	}
+
+	err := client.PublishS3ObjectSet(items, "staging", "release")
+	require.True(t, CheckErrCode(err, "InternalError"))
+	require.Equal(t, []string{"release/a.json"}, fake.sortedKeys())
+	obj, _ := fake.object("release/a.json")
+	require.Equal(t, []byte("old a"), obj.data)
+	require.Zero(t, fake.numCalls("CopyObject"))
+
+	delete(fake.hooks, "PutObject")
+	require.NoError(t, client.PublishS3ObjectSet(items, "staging", "release"))
+	require.Equal(t, []string{"release/a.json", "release/b.json", "release/c/d.json"}, fake.sortedKeys())
+	for relKey, data := range items {
+		obj, _ := fake.object("release/" + relKey)
+		require.Equal(t, data, obj.data)
+	}
+}
+
+func deletedKeys(input *s3.DeleteObjectsInput) []string {
+	var keys []string
+	for _, obj := range input.Delete.Objects {
+		keys = append(keys, *obj.Key)
+	}
+	return keys
+}
+
+func TestReencryptS3Prefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("dir/a", []byte("a"))
+	fake.putObject("dir/b b", []byte("b"))
+	fake.putObject("other", []byte("other"))
+	obj, _ := fake.object("dir/a")
+	obj.metadata = map[string]*string{"Owner": aws.String("team")}
+
+	require.NoError(t, client.ReencryptS3Prefix("dir/"))
+	require.Equal(t, 2, fake.numCalls("CopyObject"))
+
+	input := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(input.ServerSideEncryption))
+	require.Equal(t, s3.MetadataDirectiveCopy, aws.StringValue(input.MetadataDirective))
+	require.Equal(t, *input.Key, strings.TrimPrefix(mustPathUnescape(t, *input.CopySource), _testBucket+"/"))
+
+	obj, _ = fake.object("dir/a")
+	require.Equal(t, []byte("a"), obj.data)
+	require.Equal(t, "team", aws.StringValue(obj.metadata["Owner"]))
+
+	client.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+	require.NoError(t, client.ReencryptS3Object("other"))
+	input = fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.ServerSideEncryptionAwsKms, aws.StringValue(input.ServerSideEncryption))
+}
+
+func TestReencryptS3ObjectWithSSECustomerKey(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.SSECustomerKey = "0123456789abcdef0123456789abcdef"
+	fake.putObject("secret", []byte("secret"))
+
+	require.NoError(t, client.ReencryptS3Object("secret"))
+
+	// the source is read with the SSE-C key, and the copy is stored with SSE-S3 only
+	input := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(input.ServerSideEncryption))
+	require.Nil(t, input.SSECustomerAlgorithm)
+	require.Nil(t, input.SSECustomerKey)
+	require.Nil(t, input.SSECustomerKeyMD5)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(input.CopySourceSSECustomerAlgorithm))
+	require.Equal(t, client.SSECustomerKey, aws.StringValue(input.CopySourceSSECustomerKey))
+	require.NotEmpty(t, aws.StringValue(input.CopySourceSSECustomerKeyMD5))
+}
+
+func TestTransitionS3PrefixStorageClass(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("dir/a", []byte("a"))
+	fake.putObject("dir/b", []byte("b"))
+	fake.putObject("dir/archived", []byte("archived"))
+	fake.putObject("other", []byte("other"))
+	obj, _ := fake.object("dir/a")
+	obj.metadata = map[string]*string{"Owner": aws.String("team")}
+	obj, _ = fake.object("dir/archived")
+	obj.storageClass = s3.StorageClassGlacier
+
+	err := client.TransitionS3PrefixStorageClass("dir/", "COLD")
+	require.Error(t, err)
+	require.Equal(t, ErrInvalidS3StorageClass, errors.Cause(err).(Error).Kind)
+
+	numCalls := fake.numCalls("CopyObject")
+	require.NoError(t, client.TransitionS3PrefixStorageClass("dir/", s3.StorageClassGlacier))
+	require.Equal(t, numCalls+2, fake.numCalls("CopyObject"))
+
+	var copiedKeys []string
+	for _, call := range fake.calls {
+		if input, ok := call.params.(*s3.CopyObjectInput); ok {
+			require.Equal(t, s3.StorageClassGlacier, aws.StringValue(input.StorageClass))
+			require.Equal(t, s3.MetadataDirectiveCopy, aws.StringValue(input.MetadataDirective))
+			copiedKeys = append(copiedKeys, *input.Key)
+		}
+	}
+	require.ElementsMatch(t, []string{"dir/a", "dir/b"}, copiedKeys)
+
+	obj, _ = fake.object("dir/a")
+	require.Equal(t, []byte("a"), obj.data)
+	require.Equal(t, "team", aws.StringValue(obj.metadata["Owner"]))
+	obj, _ = fake.object("other")
+	require.Empty(t, obj.storageClass)
+}
+
+func TestTransformS3Object(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("hello"), "src", UploadOptions{ContentType: "text/plain"}))
+	upper := func(data []byte) ([]byte, error) {
+		return bytes.ToUpper(data), nil
+	}
+
+	require.NoError(t, client.TransformS3Object("src", "dest", upper))
+	dest, _ := fake.object("dest")
+	require.Equal(t, []byte("HELLO"), dest.data)
+	require.Equal(t, "text/plain", dest.contentType)
+	src, _ := fake.object("src")
+	require.Equal(t, []byte("hello"), src.data)
+
+	require.NoError(t, client.TransformS3Object("src", "src", upper))
+	src, _ = fake.object("src")
+	require.Equal(t, []byte("HELLO"), src.data)
+
+	err := client.TransformS3Object("src", "failed", func(data []byte) ([]byte, error) {
+		return nil, errors.New("invalid input")
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid input")
+	_, ok := fake.object("failed")
+	require.False(t, ok)
+}
+
+func TestUploadBytesToS3Verified(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.UploadBytesToS3Verified([]byte("critical"), "critical"))
+	obj, _ := fake.object("critical")
+	require.Equal(t, []byte("critical"), obj.data)
+
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		output := r.Data.(*s3.GetObjectOutput)
+		output.Body = ioutil.NopCloser(strings.NewReader("corrupted"))
+		output.ContentLength = aws.Int64(int64(len("corrupted")))
+		return true
+	}
+	err := client.UploadBytesToS3Verified([]byte("critical"), "critical")
+	require.Equal(t, ErrS3IntegrityCheckFailed, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "critical")
+}
+
+func TestUploadBytesToS3WithBackup(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	require.NoError(t, client.UploadBytesToS3WithBackup([]byte("v1"), "config.yaml", "config.yaml.bak"))
+	require.Equal(t, []string{"config.yaml"}, fake.sortedKeys())
+
+	require.NoError(t, client.UploadBytesToS3WithBackup([]byte("v2"), "config.yaml", "config.yaml.bak"))
+	obj, _ := fake.object("config.yaml")
+	require.Equal(t, []byte("v2"), obj.data)
+	backup, ok := fake.object("config.yaml.bak")
+	require.True(t, ok)
+	require.Equal(t, []byte("v1"), backup.data)
+
+	// the upload doesn't happen if the backup fails
+	fake.hooks["CopyObject"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+	require.Error(t, client.UploadBytesToS3WithBackup([]byte("v3"), "config.yaml", "config.yaml.bak"))
+	obj, _ = fake.object("config.yaml")
+	require.Equal(t, []byte("v2"), obj.data)
+}
+
+func TestCopyS3ToS3IfMatch(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("src", []byte("validated"))
+	src, _ := fake.object("src")
+	validatedETag := src.etag
+
+	require.NoError(t, client.CopyS3ToS3WithOptions("src", "dest", CopyOptions{CopySourceIfMatch: validatedETag}))
+	input := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, validatedETag, aws.StringValue(input.CopySourceIfMatch))
+
+	// a concurrent overwrite changes the source's ETag
+	fake.putObject("src", []byte("overwritten"))
+	err := client.CopyS3ToS3WithOptions("src", "dest2", CopyOptions{CopySourceIfMatch: validatedETag})
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "src")
+	_, ok := fake.object("dest2")
+	require.False(t, ok)
+}
+
+func TestDeleteS3FileIfETagMatches(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("file", []byte("validated"))
+	obj, _ := fake.object("file")
+	validatedETag := obj.etag
+
+	// the object was overwritten since it was validated
+	fake.putObject("file", []byte("overwritten"))
+	err := client.DeleteS3FileIfETagMatches("file", validatedETag)
+	require.Equal(t, ErrPreconditionFailed, errors.Cause(err).(Error).Kind)
+	_, ok := fake.object("file")
+	require.True(t, ok)
+
+	obj, _ = fake.object("file")
+	require.NoError(t, client.DeleteS3FileIfETagMatches("file", strings.Trim(obj.etag, `"`)))
+	_, ok = fake.object("file")
+	require.False(t, ok)
+
+	require.Error(t, client.DeleteS3FileIfETagMatches("file", validatedETag))
+}
+
+func mustPathUnescape(t *testing.T, s string) string {
+	unescaped, err := url.PathUnescape(s)
+	require.NoError(t, err)
+	return unescaped
+}
+
+func TestCopyS3ToS3WithOptions(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("data"), "src", UploadOptions{ContentType: "text/plain"}))
+	src, _ := fake.object("src")
+	src.metadata = map[string]*string{"Owner": aws.String("team")}
+
+	require.NoError(t, client.CopyS3ToS3("src", "default"))
+	input := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.ObjectCannedACLPrivate, aws.StringValue(input.ACL))
+	require.Equal(t, s3.MetadataDirectiveCopy, aws.StringValue(input.MetadataDirective))
+	require.Nil(t, input.StorageClass)
+	obj, _ := fake.object("default")
+	require.Equal(t, "team", aws.StringValue(obj.metadata["Owner"]))
+
+	require.NoError(t, client.CopyS3ToS3WithOptions("src", "public", CopyOptions{
+		ACL:          s3.ObjectCannedACLPublicRead,
+		Metadata:     map[string]string{"Reviewed": "true"},
+		StorageClass: s3.StorageClassStandardIa,
+	}))
+	input = fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.ObjectCannedACLPublicRead, aws.StringValue(input.ACL))
+	require.Equal(t, s3.MetadataDirectiveReplace, aws.StringValue(input.MetadataDirective))
+	require.Equal(t, s3.StorageClassStandardIa, aws.StringValue(input.StorageClass))
+	obj, _ = fake.object("public")
+	require.Equal(t, map[string]*string{"Reviewed": aws.String("true")}, obj.metadata)
+	require.Equal(t, "text/plain", obj.contentType)
+}
+
+func TestCopyS3ToS3ContentType(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("{}"), "src", UploadOptions{
+		ContentType: "application/json",
+		Metadata:    map[string]string{"Owner": "team"},
+	}))
+
+	require.NoError(t, client.CopyS3ToS3("src", "copied"))
+	input := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.MetadataDirectiveCopy, aws.StringValue(input.MetadataDirective))
+	require.Nil(t, input.ContentType)
+	metadata, err := client.GetS3ObjectMetadata("copied")
+	require.NoError(t, err)
+	require.Equal(t, "application/json", metadata.ContentType)
+	require.Equal(t, "team", metadataValue(metadata.Metadata, "owner"))
+
+	require.NoError(t, client.CopyS3ToS3WithOptions("src", "retyped", CopyOptions{ContentType: "text/plain"}))
+	input = fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.MetadataDirectiveReplace, aws.StringValue(input.MetadataDirective))
+	metadata, err = client.GetS3ObjectMetadata("retyped")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", metadata.ContentType)
+	require.Equal(t, "team", metadataValue(metadata.Metadata, "owner"))
+
+	require.NoError(t, client.CopyS3ToS3WithOptions("src", "both", CopyOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"Reviewed": "true"},
+	}))
+	metadata, err = client.GetS3ObjectMetadata("both")
+	require.NoError(t, err)
+	require.Equal(t, "text/plain", metadata.ContentType)
+	require.Equal(t, map[string]*string{"Reviewed": aws.String("true")}, metadata.Metadata)
+}
+
+func TestCopyS3ToS3ReplaceKeepsHeaders(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.UploadGzipBytesToS3([]byte(`{"a": 1}`), "src.json"))
+	src, _ := fake.object("src.json")
+	src.cacheControl = "max-age=60"
+	src.websiteRedirectLocation = "/other.json"
+
+	// replacing the content type replaces every header, so the source's others must be sent with it
+	require.NoError(t, client.CopyS3ToS3WithOptions("src.json", "dest.json", CopyOptions{ContentType: "text/plain"}))
+	input := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, s3.MetadataDirectiveReplace, aws.StringValue(input.MetadataDirective))
+
+	dest, _ := fake.object("dest.json")
+	require.Equal(t, "text/plain", dest.contentType)
+	require.Equal(t, "gzip", dest.contentEncoding)
+	require.Equal(t, "max-age=60", dest.cacheControl)
+	require.Equal(t, "/other.json", dest.websiteRedirectLocation)
+
+	data, err := client.ReadBytesFromS3Decoded("dest.json")
+	require.NoError(t, err)
+	require.Equal(t, []byte(`{"a": 1}`), data)
+}
+
+// failingReader returns the first n bytes of data, and then an error
+type failingReader struct {
+	data []byte
+	n    int
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, fmt.Errorf("connection reset by peer")
+	}
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	r.n -= n
+	return n, nil
+}
+
+func TestResumableReadBytesFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	fake.putObject("object", data)
+	obj, _ := fake.object("object")
+
+	failures := 0
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		if failures == 2 {
+			return false
+		}
+		start := 0
+		if rangeHeader := r.Params.(*s3.GetObjectInput).Range; rangeHeader != nil {
+			start64, _, _ := parseRange(*rangeHeader, int64(len(data)))
+			start = int(start64)
+		}
+		failures++
+		output := r.Data.(*s3.GetObjectOutput)
+		output.Body = ioutil.NopCloser(&failingReader{data: data[start:], n: 300})
+		output.ContentLength = aws.Int64(int64(len(data) - start))
+		output.ETag = aws.String(obj.etag)
+		return true
+	}
+
+	result, err := client.ResumableReadBytesFromS3("object")
+	require.NoError(t, err)
+	require.Equal(t, data, result)
+	require.Equal(t, 3, fake.numCalls("GetObject"))
+
+	input := fake.lastInput("GetObject").(*s3.GetObjectInput)
+	require.Equal(t, "bytes=600-", aws.StringValue(input.Range))
+	require.Equal(t, obj.etag, aws.StringValue(input.IfMatch))
+
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		r.Data.(*s3.GetObjectOutput).Body = ioutil.NopCloser(&failingReader{data: data, n: 0})
+		return true
+	}
+	_, err = client.ResumableReadBytesFromS3("object")
+	require.Error(t, err)
+}
+
+func TestBucketNotConfigured(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.Bucket = ""
+
+	_, err := client.ReadBytesFromS3("object")
+	require.Equal(t, ErrBucketNotConfigured, errors.Cause(err).(Error).Kind)
+
+	err = client.UploadJSONLinesToS3([]interface{}{1}, "object")
+	require.Equal(t, ErrBucketNotConfigured, errors.Cause(err).(Error).Kind)
+
+	require.Empty(t, fake.calls)
+}
+
+func TestReadBytesFromS3Decoded(t *testing.T) {
+	client, _ := newFakeS3Client()
+	data := []byte("plain text content")
+	require.NoError(t, client.UploadGzipBytesToS3(data, "gzipped"))
+	require.NoError(t, client.UploadBytesToS3(data, "plain"))
+
+	raw, err := client.ReadBytesFromS3("gzipped")
+	require.NoError(t, err)
+	require.NotEqual(t, data, raw)
+
+	decoded, err := client.ReadBytesFromS3Decoded("gzipped")
+	require.NoError(t, err)
+	require.Equal(t, data, decoded)
+
+	decoded, err = client.ReadBytesFromS3Decoded("plain")
+	require.NoError(t, err)
+	require.Equal(t, data, decoded)
+}
+
+func TestReadZstdFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	// compressed with `zstd -19`
+	fake.putObject("data.zst", []byte{
+		0x28, 0xb5, 0x2f, 0xfd, 0x64, 0xb4, 0x03, 0x8d, 0x00, 0x00, 0x50, 0x6d, 0x6f, 0x64, 0x65, 0x6c,
+		0x2d, 0x65, 0x6e, 0x64, 0x0a, 0x01, 0x00, 0xa7, 0xe4, 0x2f, 0x45, 0xd1, 0xbb, 0x84, 0xe1,
+	})
+	data, err := client.ReadZstdFromS3("data.zst")
+	require.NoError(t, err)
+	require.Equal(t, strings.Repeat("model-", 200)+"end\n", string(data))
+
+	fake.putObject("plain.zst", []byte("not compressed"))
+	_, err = client.ReadZstdFromS3("plain.zst")
+	require.Error(t, err)
+
+	_, err = client.ReadZstdFromS3("missing.zst")
+	require.True(t, IsNoSuchKeyErr(err))
+}
+
+func TestGroupS3ObjectsByDir(t *testing.T) {
+	client, fake := newFakeS3Client()
+	for _, key := range []string{"data/README", "data/train/1.csv", "data/train/2.csv", "data/test/1.csv", "data/test/nested/2.csv", "other/1.csv"} {
+		fake.putObject(key, []byte(key))
+	}
+
+	groupKeys := func(groups map[string][]*s3.Object) map[string][]string {
+		keys := map[string][]string{}
+		for dir, objects := range groups {
+			for _, object := range objects {
+				keys[dir] = append(keys[dir], *object.Key)
+			}
+		}
+		return keys
+	}
+
+	groups, err := client.GroupS3ObjectsByDir("data/")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"":      {"data/README"},
+		"test":  {"data/test/1.csv", "data/test/nested/2.csv"},
+		"train": {"data/train/1.csv", "data/train/2.csv"},
+	}, groupKeys(groups))
+
+	groups, err = client.GroupS3ObjectsByDir("data")
+	require.NoError(t, err)
+	require.Len(t, groups, 3)
+	require.Len(t, groups["test"], 2)
+
+	groups, err = client.GroupS3ObjectsByDir("missing/")
+	require.NoError(t, err)
+	require.Empty(t, groups)
+}
+
+func TestListPrefixWithDeadline(t *testing.T) {
+	client, fake := newFakeS3Client()
+	for i := 0; i < 10; i++ {
+		fake.putObject(fmt.Sprintf("dir/%d", i), []byte("data"))
+	}
+
+	objects, complete, err := client.ListPrefixWithDeadline("dir/", time.Minute)
+	require.NoError(t, err)
+	require.True(t, complete)
+	require.Len(t, objects, 10)
+
+	// each page of 2 objects takes 50ms, so only some of the 5 pages are listed before the deadline
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		r.Params.(*s3.ListObjectsV2Input).MaxKeys = aws.Int64(2)
+		time.Sleep(50 * time.Millisecond)
+		return false
+	}
+
+	objects, complete, err = client.ListPrefixWithDeadline("dir/", 120*time.Millisecond)
+	require.NoError(t, err)
+	require.False(t, complete)
+	require.NotEmpty(t, objects)
+	require.True(t, len(objects) < 10)
+	require.Equal(t, "dir/0", *objects[0].Key)
+
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", 403)
+		return true
+	}
+	_, _, err = client.ListPrefixWithDeadline("dir/", time.Minute)
+	require.Error(t, err)
+}
+
+func TestListPrefixModifiedSince(t *testing.T) {
+	client, fake := newFakeS3Client()
+	cutoff := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for key, lastModified := range map[string]time.Time{
+		"dir/old":    cutoff.Add(-time.Hour),
+		"dir/cutoff": cutoff,
+		"dir/new":    cutoff.Add(time.Hour),
+		"other/new":  cutoff.Add(time.Hour),
+	} {
+		fake.putObject(key, []byte(key))
+		obj, _ := fake.object(key)
+		obj.lastModified = lastModified
+	}
+
+	objects, err := client.ListPrefixModifiedSince("dir/", cutoff)
+	require.NoError(t, err)
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, *object.Key)
+	}
+	require.ElementsMatch(t, []string{"dir/cutoff", "dir/new"}, keys)
+}
+
+func TestDeleteS3ObjectsOlderThan(t *testing.T) {
+	client, fake := newFakeS3Client()
+	now := time.Now()
+	for key, lastModified := range map[string]time.Time{
+		"logs/old-1":  now.Add(-72 * time.Hour),
+		"logs/old-2":  now.Add(-49 * time.Hour),
+		"logs/new-1":  now.Add(-47 * time.Hour),
+		"logs/new-2":  now,
+		"other/old-1": now.Add(-72 * time.Hour),
+	} {
+		fake.putObject(key, []byte(key))
+		obj, _ := fake.object(key)
+		obj.lastModified = lastModified
+	}
+
+	numDeletes := fake.numCalls("DeleteObjects")
+	deleted, err := client.DeleteS3ObjectsOlderThan("logs/", 48*time.Hour, true)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.Equal(t, numDeletes, fake.numCalls("DeleteObjects"))
+	require.Len(t, fake.sortedKeys(), 5)
+
+	deleted, err = client.DeleteS3ObjectsOlderThan("logs/", 48*time.Hour, false)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), deleted)
+	require.Equal(t, []string{"logs/new-1", "logs/new-2", "other/old-1"}, fake.sortedKeys())
+
+	deleted, err = client.DeleteS3ObjectsOlderThan("logs/", 48*time.Hour, false)
+	require.NoError(t, err)
+	require.Zero(t, deleted)
+}
+
+func TestListPrefixSinceLocalMax(t *testing.T) {
+	client, fake := newFakeS3Client()
+	cutoff := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	for key, lastModified := range map[string]time.Time{
+		"dir/old":    cutoff.Add(-time.Hour),
+		"dir/cutoff": cutoff,
+		"dir/new":    cutoff.Add(time.Hour),
+		"other/new":  cutoff.Add(time.Hour),
+	} {
+		fake.putObject(key, []byte(key))
+		obj, _ := fake.object(key)
+		obj.lastModified = lastModified
+	}
+
+	localDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+
+	objects, err := client.ListPrefixSinceLocalMax("dir/", localDir)
+	require.NoError(t, err)
+	require.Len(t, objects, 3)
+
+	for path, modTime := range map[string]time.Time{
+		filepath.Join(localDir, "a"):        cutoff.Add(-2 * time.Hour),
+		filepath.Join(localDir, "sub", "b"): cutoff,
+	} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	objects, err = client.ListPrefixSinceLocalMax("dir/", localDir)
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	require.Equal(t, "dir/new", *objects[0].Key)
+
+	_, err = client.ListPrefixSinceLocalMax("dir/", filepath.Join(localDir, "missing"))
+	require.Error(t, err)
+}
+
+func TestUploadFileToS3UnderPrefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	localRoot, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(localRoot)
+
+	relPaths := []string{"model.pb", filepath.Join("variables", "index"), filepath.Join("variables", "data", "shard-0")}
+	for _, relPath := range relPaths {
+		path := filepath.Join(localRoot, relPath)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		require.NoError(t, ioutil.WriteFile(path, []byte(relPath), 0644))
+		require.NoError(t, client.UploadFileToS3UnderPrefix(path, localRoot, "models/v1/"))
+	}
+	require.NoError(t, client.UploadFileToS3UnderPrefix(filepath.Join(localRoot, "model.pb"), localRoot+"/", "no-slash"))
+
+	require.Equal(t, []string{
+		"models/v1/model.pb",
+		"models/v1/variables/data/shard-0",
+		"models/v1/variables/index",
+		"no-slash/model.pb",
+	}, fake.sortedKeys())
+	obj, _ := fake.object("models/v1/variables/data/shard-0")
+	require.Equal(t, []byte(filepath.Join("variables", "data", "shard-0")), obj.data)
+
+	err = client.UploadFileToS3UnderPrefix(filepath.Join(localRoot, "model.pb"), filepath.Join(localRoot, "variables"), "models/")
+	require.Error(t, err)
+}
+
+func TestUploadDetectsContentType(t *testing.T) {
+	client, fake := newFakeS3Client()
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 1000)...)
+
+	require.NoError(t, client.UploadBytesToS3(png, "blob"))
+	obj, _ := fake.object("blob")
+	require.Equal(t, "image/png", obj.contentType)
+
+	require.NoError(t, client.UploadBytesToS3([]byte("%PDF-1.4\n"), "reports/latest"))
+	obj, _ = fake.object("reports/latest")
+	require.Equal(t, "application/pdf", obj.contentType)
+
+	// an explicit content type, a known extension, an encoding, or unrecognized content aren't sniffed
+	require.NoError(t, client.UploadBytesToS3WithOptions(png, "explicit", UploadOptions{ContentType: "application/x-custom"}))
+	obj, _ = fake.object("explicit")
+	require.Equal(t, "application/x-custom", obj.contentType)
+
+	require.NoError(t, client.UploadBytesToS3(png, "image.json"))
+	obj, _ = fake.object("image.json")
+	require.Empty(t, obj.contentType)
+
+	require.NoError(t, client.UploadBytesToS3WithOptions(png, "encoded", UploadOptions{ContentEncoding: "identity"}))
+	obj, _ = fake.object("encoded")
+	require.Empty(t, obj.contentType)
+
+	require.NoError(t, client.UploadBytesToS3([]byte{0x00, 0x01, 0x02}, "binary"))
+	obj, _ = fake.object("binary")
+	require.Empty(t, obj.contentType)
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.SSECustomerKey = "0123456789abcdef0123456789abcdef"
+	keyMD5 := md5.Sum([]byte(client.SSECustomerKey))
+	encodedKeyMD5 := base64.StdEncoding.EncodeToString(keyMD5[:])
+
+	require.NoError(t, client.UploadStringToS3("secret", "secret.txt"))
+	putInput := fake.lastInput("PutObject").(*s3.PutObjectInput)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(putInput.SSECustomerAlgorithm))
+	require.Equal(t, client.SSECustomerKey, aws.StringValue(putInput.SSECustomerKey))
+	require.Equal(t, encodedKeyMD5, aws.StringValue(putInput.SSECustomerKeyMD5))
+	require.Nil(t, putInput.ServerSideEncryption)
+
+	data, err := client.ReadBytesFromS3("secret.txt")
+	require.NoError(t, err)
+	require.Equal(t, []byte("secret"), data)
+	getInput := fake.lastInput("GetObject").(*s3.GetObjectInput)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(getInput.SSECustomerAlgorithm))
+	require.Equal(t, client.SSECustomerKey, aws.StringValue(getInput.SSECustomerKey))
+	require.Equal(t, encodedKeyMD5, aws.StringValue(getInput.SSECustomerKeyMD5))
+
+	require.NoError(t, client.CopyS3ToS3("secret.txt", "copy.txt"))
+	copyInput := fake.lastInput("CopyObject").(*s3.CopyObjectInput)
+	require.Equal(t, client.SSECustomerKey, aws.StringValue(copyInput.SSECustomerKey))
+	require.Equal(t, encodedKeyMD5, aws.StringValue(copyInput.CopySourceSSECustomerKeyMD5))
+
+	client.SSECustomerKey = ""
+	require.NoError(t, client.UploadStringToS3("public", "public.txt"))
+	putInput = fake.lastInput("PutObject").(*s3.PutObjectInput)
+	require.Nil(t, putInput.SSECustomerKey)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(putInput.ServerSideEncryption))
+}
+
+func TestUploadHeaderOptions(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	require.NoError(t, client.UploadBytesToS3([]byte("<html></html>"), "index.html"))
+	input := fake.lastInput("PutObject").(*s3.PutObjectInput)
+	require.Nil(t, input.ContentLanguage)
+	require.Nil(t, input.Expires)
+	require.Nil(t, input.WebsiteRedirectLocation)
+
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("<html></html>"), "de/index.html", UploadOptions{
+		ContentLanguage:         "de-DE",
+		Expires:                 &expires,
+		WebsiteRedirectLocation: "/de/home.html",
+	}))
+	input = fake.lastInput("PutObject").(*s3.PutObjectInput)
+	require.Equal(t, "de-DE", *input.ContentLanguage)
+	require.Equal(t, expires, *input.Expires)
+	require.Equal(t, "/de/home.html", *input.WebsiteRedirectLocation)
+}
+
+func TestEstimateDownloadBytes(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("a", make([]byte, 100))
+	fake.putObject("b", make([]byte, 2500))
+	fake.putObject("c", []byte{})
+
+	numGets := fake.numCalls("GetObject")
+	total, err := client.EstimateDownloadBytes("a", "b", "c")
+	require.NoError(t, err)
+	require.Equal(t, int64(2600), total)
+	require.Equal(t, 3, fake.numCalls("HeadObject"))
+	require.Equal(t, numGets, fake.numCalls("GetObject"))
+
+	total, err = client.EstimateDownloadBytes()
+	require.NoError(t, err)
+	require.Zero(t, total)
+
+	_, err = client.EstimateDownloadBytes("a", "missing")
+	require.True(t, IsNotFoundErr(err))
+}
+
+func TestReadBytesFollowingRedirect(t *testing.T) {
+	client, _ := newFakeS3Client()
+	redirect := func(key string, location string) {
+		require.NoError(t, client.UploadBytesToS3WithOptions([]byte{}, key, UploadOptions{WebsiteRedirectLocation: location}))
+	}
+
+	require.NoError(t, client.UploadStringToS3("v2 content", "docs/v2/index.html"))
+	redirect("docs/latest/index.html", "/docs/v2/index.html")
+	redirect("index.html", "/docs/latest/index.html")
+
+	data, err := client.ReadBytesFollowingRedirect("index.html")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2 content"), data)
+
+	data, err = client.ReadBytesFollowingRedirect("docs/v2/index.html")
+	require.NoError(t, err)
+	require.Equal(t, []byte("v2 content"), data)
+
+	redirect("a", "/b")
+	redirect("b", "/a")
+	_, err = client.ReadBytesFollowingRedirect("a")
+	require.Equal(t, ErrS3RedirectNotFollowed, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "redirect loop")
+
+	redirect("external", "https://example.com/index.html")
+	_, err = client.ReadBytesFollowingRedirect("external")
+	require.Equal(t, ErrS3RedirectNotFollowed, errors.Cause(err).(Error).Kind)
+
+	redirect("dangling", "/missing")
+	_, err = client.ReadBytesFollowingRedirect("dangling")
+	require.True(t, IsNoSuchKeyErr(err))
+}
+
+func TestSyncDirToS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	localDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(localDir)
+
+	relPaths := []string{"index.html", filepath.Join("static", "app.js"), filepath.Join("static", "css", "app.css")}
+	for _, relPath := range relPaths {
+		path := filepath.Join(localDir, relPath)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		require.NoError(t, ioutil.WriteFile(path, []byte(relPath), 0644))
+	}
+
+	options := UploadOptions{ACL: s3.ObjectCannedACLPublicRead, ServerSideEncryption: ServerSideEncryptionNone}
+	require.NoError(t, client.SyncDirToS3(localDir, "site", options))
+
+	require.Equal(t, []string{"site/index.html", "site/static/app.js", "site/static/css/app.css"}, fake.sortedKeys())
+	numPuts := 0
+	for _, call := range fake.calls {
+		if call.operation != "PutObject" {
+			continue
+		}
+		numPuts++
+		input := call.params.(*s3.PutObjectInput)
+		require.Equal(t, s3.ObjectCannedACLPublicRead, *input.ACL)
+		require.Nil(t, input.ServerSideEncryption)
+	}
+	require.Equal(t, len(relPaths), numPuts)
+
+	// other uploads are unaffected
+	require.NoError(t, client.UploadStringToS3("x", "other"))
+	input := fake.lastInput("PutObject").(*s3.PutObjectInput)
+	require.Equal(t, "private", *input.ACL)
+	require.Equal(t, s3.ServerSideEncryptionAes256, *input.ServerSideEncryption)
+
+	require.Error(t, client.SyncDirToS3(filepath.Join(localDir, "missing"), "site", options))
+}
+
+func TestDeleteFromS3ByPrefixRefusesEmptyPrefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("a", []byte("a"))
+	fake.putObject("dir/b", []byte("b"))
+
+	for _, prefix := range []string{"", "  ", "\t\n"} {
+		err := client.DeleteFromS3ByPrefix(prefix, false, false)
+		require.Equal(t, ErrS3DeleteAllNotAllowed, errors.Cause(err).(Error).Kind)
+	}
+	require.Zero(t, fake.numCalls("ListObjectsV2"))
+	require.Equal(t, []string{"a", "dir/b"}, fake.sortedKeys())
+
+	require.NoError(t, client.DeleteFromS3ByPrefix("", false, true))
+	require.Empty(t, fake.sortedKeys())
+}
+
+func TestDeleteFromS3ByPrefixWithContext(t *testing.T) {
+	client, fake := newFakeS3Client()
+	for i := 0; i < 5; i++ {
+		fake.putObject(fmt.Sprintf("dir/%d", i), []byte("data"))
+	}
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		r.Params.(*s3.ListObjectsV2Input).MaxKeys = aws.Int64(2)
+		return false
+	}
+
+	// the operator shuts down while the first page is being deleted
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fake.hooks["DeleteObjects"] = func(r *request.Request) bool {
+		cancel()
+		return false
+	}
+
+	numDeletes := fake.numCalls("DeleteObjects")
+	err := client.DeleteFromS3ByPrefixWithContext(ctx, "dir/", true, false)
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, numDeletes+1, fake.numCalls("DeleteObjects"))
+	require.Equal(t, []string{"dir/2", "dir/3", "dir/4"}, fake.sortedKeys())
+
+	delete(fake.hooks, "DeleteObjects")
+	require.NoError(t, client.DeleteFromS3ByPrefixWithContext(context.Background(), "dir/", false, false))
+	require.Empty(t, fake.sortedKeys())
+}
+
+func TestReadFirstObjectUnderPrefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("markers/b", []byte("b"))
+	fake.putObject("markers/a", []byte("a"))
+	fake.putObject("markersx", []byte("x"))
+
+	key, data, err := client.ReadFirstObjectUnderPrefix("markers/")
+	require.NoError(t, err)
+	require.Equal(t, "markers/a", key)
+	require.Equal(t, []byte("a"), data)
+	input := fake.lastInput("ListObjectsV2").(*s3.ListObjectsV2Input)
+	require.Equal(t, int64(1), aws.Int64Value(input.MaxKeys))
+
+	_, _, err = client.ReadFirstObjectUnderPrefix("empty/")
+	require.Equal(t, ErrS3PrefixEmpty, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "empty/")
+}
+
+func TestDownloadMatchingFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("models/small", []byte("small"))
+	fake.putObject("models/large", []byte("large object"))
+	fake.putObject("models/sub/", []byte{})
+	fake.putObject("models/sub/large", []byte("another large object"))
+	fake.putObject("other/large", []byte("not under the prefix"))
+
+	destDir, err := ioutil.TempDir("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(destDir)
+
+	isLarge := func(object *s3.Object) bool {
+		return aws.Int64Value(object.Size) > 5
+	}
+	require.NoError(t, client.DownloadMatchingFromS3("models/", isLarge, destDir))
+
+	localPaths, err := files.ListDirRecursive(destDir, true)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"large", filepath.Join("sub", "large")}, localPaths)
+	data, err := ioutil.ReadFile(filepath.Join(destDir, "sub", "large"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("another large object"), data)
+
+	fake.putObject("models/../escaped", []byte("escaped object"))
+	require.Error(t, client.DownloadMatchingFromS3("models/", isLarge, destDir))
+}
+
+func TestHeadObjectIfModifiedSince(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("config.yaml", []byte("replicas: 1"))
+	obj, _ := fake.object("config.yaml")
+	lastPoll := obj.lastModified.Add(time.Second)
+
+	changed, metadata, err := client.HeadObjectIfModifiedSince("config.yaml", lastPoll)
+	require.NoError(t, err)
+	require.False(t, changed)
+	require.Nil(t, metadata)
+	require.Equal(t, lastPoll, *fake.lastInput("HeadObject").(*s3.HeadObjectInput).IfModifiedSince)
+
+	obj.lastModified = lastPoll.Add(time.Second)
+	changed, metadata, err = client.HeadObjectIfModifiedSince("config.yaml", lastPoll)
+	require.NoError(t, err)
+	require.True(t, changed)
+	require.Equal(t, obj.lastModified, metadata.LastModified)
+	require.Equal(t, int64(len("replicas: 1")), metadata.Size)
+
+	_, _, err = client.HeadObjectIfModifiedSince("missing", lastPoll)
+	require.True(t, IsNotFoundErr(err))
+}
+
+func TestIsS3Dir(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	isDir, err := client.IsS3Dir("")
+	require.NoError(t, err)
+	require.False(t, isDir)
+
+	fake.putObject("dir/file", []byte("data"))
+	fake.putObject("dirx", []byte("data"))
+
+	for _, tc := range []struct {
+		dirPath string
+		prefix  string
+		isDir   bool
+	}{
+		{dirPath: "dir", prefix: "dir/", isDir: true},
+		{dirPath: "dir/", prefix: "dir/", isDir: true},
+		{dirPath: "", prefix: "", isDir: true},
+		{dirPath: "dirx", prefix: "dirx/", isDir: false},
+		{dirPath: "missing/", prefix: "missing/", isDir: false},
+	} {
+		isDir, err := client.IsS3Dir(tc.dirPath)
+		require.NoError(t, err)
+		require.Equal(t, tc.isDir, isDir, tc.dirPath)
+		require.Equal(t, tc.prefix, *fake.lastInput("ListObjectsV2").(*s3.ListObjectsV2Input).Prefix, tc.dirPath)
+	}
+}
+
+func TestCreateS3DirMarker(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("foo/file", []byte("data"))
+	fake.putObject("bar/", []byte("not a marker"))
+
+	require.NoError(t, client.CreateS3DirMarker("foo"))
+	obj, ok := fake.object("foo/")
+	require.True(t, ok)
+	require.Empty(t, obj.data)
+
+	isMarker, err := client.IsS3DirMarker("foo/")
+	require.NoError(t, err)
+	require.True(t, isMarker)
+
+	objects, dirs, err := client.ListS3Level("foo/")
+	require.NoError(t, err)
+	require.Empty(t, dirs)
+	require.Len(t, objects, 2)
+	for _, object := range objects {
+		require.Equal(t, *object.Key == "foo/", isS3DirMarker(*object.Key, *object.Size))
+	}
+
+	isFile, err := client.IsS3File("foo/")
+	require.NoError(t, err)
+	require.False(t, isFile)
+
+	isFile, err = client.IsS3File("bar/")
+	require.NoError(t, err)
+	require.True(t, isFile)
+	isMarker, err = client.IsS3DirMarker("bar/")
+	require.NoError(t, err)
+	require.False(t, isMarker)
+}
+
+func TestServerSideEncryptionNone(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	require.NoError(t, client.UploadBytesToS3([]byte("data"), "encrypted"))
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(fake.lastInput("PutObject").(*s3.PutObjectInput).ServerSideEncryption))
+
+	client.ServerSideEncryption = ServerSideEncryptionNone
+	require.NoError(t, client.UploadBytesToS3([]byte("data"), "unencrypted"))
+	require.Nil(t, fake.lastInput("PutObject").(*s3.PutObjectInput).ServerSideEncryption)
+
+	require.NoError(t, client.CopyS3ToS3("unencrypted", "copy"))
+	require.Nil(t, fake.lastInput("CopyObject").(*s3.CopyObjectInput).ServerSideEncryption)
+}
+
+func TestListingRetriesSlowDown(t *testing.T) {
+	defer func(delay time.Duration) { _s3ThrottleInitialDelay = delay }(_s3ThrottleInitialDelay)
+	_s3ThrottleInitialDelay = time.Millisecond
+
+	client, fake := newFakeS3Client()
+	for i := 0; i < 5; i++ {
+		fake.putObject(fmt.Sprintf("dir/%d", i), []byte("data"))
+	}
+
+	// throttle the first attempt at the second page
+	throttled := false
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		if r.Params.(*s3.ListObjectsV2Input).ContinuationToken != nil && !throttled {
+			throttled = true
+			r.Error = fakeS3Err("SlowDown", http.StatusServiceUnavailable)
+			return true
+		}
+		return false
+	}
+
+	var keys []string
+	err := client.listObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(_testBucket),
+		Prefix:  aws.String("dir/"),
+		MaxKeys: aws.Int64(2),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range output.Contents {
+			keys = append(keys, *object.Key)
+		}
+		return true
+	})
+	require.NoError(t, err)
+	require.True(t, throttled)
+	require.Equal(t, []string{"dir/0", "dir/1", "dir/2", "dir/3", "dir/4"}, keys)
+
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("SlowDown", http.StatusServiceUnavailable)
+		return true
+	}
+	_, err = client.ListPrefix("dir/", 10)
+	require.True(t, IsSlowDownErr(err))
+	require.Equal(t, 4+_s3ThrottleMaxRetries+1, fake.numCalls("ListObjectsV2"))
+
+	// the backoff stops as soon as the context is done
+	_s3ThrottleInitialDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = client.listObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(_testBucket),
+		Prefix: aws.String("dir/"),
+	}, func(output *s3.ListObjectsV2Output, lastPage bool) bool { return true })
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestListPrefixKeys(t *testing.T) {
+	client, fake := newFakeS3Client()
+	for i := 0; i < 5; i++ {
+		fake.putObject(fmt.Sprintf("dir/%d", i), []byte("data"))
+	}
+	fake.putObject("other", []byte("data"))
+
+	// S3 limits the number of keys per page
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		input := r.Params.(*s3.ListObjectsV2Input)
+		if aws.Int64Value(input.MaxKeys) > 2 {
+			input.MaxKeys = aws.Int64(2)
+		}
+		return false
+	}
+
+	keys, err := client.ListPrefixKeys("dir/", 4)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/0", "dir/1", "dir/2", "dir/3"}, keys)
+
+	numCalls := fake.numCalls("ListObjectsV2")
+	keys, err = client.ListPrefixKeys("dir/", 10)
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/0", "dir/1", "dir/2", "dir/3", "dir/4"}, keys)
+	require.Equal(t, numCalls+3, fake.numCalls("ListObjectsV2"))
+
+	keys, err = client.ListPrefixKeys("missing/", 10)
+	require.NoError(t, err)
+	require.Empty(t, keys)
+}
+
+func TestDiffS3Prefixes(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("a/same", []byte("same"))
+	fake.putObject("b/same", []byte("same"))
+	fake.putObject("a/sub/changed", []byte("old"))
+	fake.putObject("b/sub/changed", []byte("new"))
+	fake.putObject("a/resized", []byte("data"))
+	fake.putObject("b/resized", []byte("longer data"))
+	fake.putObject("a/only-a", []byte("a"))
+	fake.putObject("b/only-b", []byte("b"))
+	fake.putObject("b/sub/only-b", []byte("b"))
+
+	diff, err := client.DiffS3Prefixes("a/", "b/")
+	require.NoError(t, err)
+	require.Equal(t, &S3PrefixDiff{
+		OnlyInA:   []string{"only-a"},
+		OnlyInB:   []string{"only-b", "sub/only-b"},
+		Different: []string{"resized", "sub/changed"},
+	}, diff)
+}
+
+func TestHashS3Prefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	for _, prefix := range []string{"a/", "b/"} {
+		fake.putObject(prefix+"x", []byte("x"))
+		fake.putObject(prefix+"sub/y", []byte("y"))
+		fake.putObject(prefix+"z", []byte("z"))
+	}
+
+	hashA, err := client.HashS3Prefix("a/")
+	require.NoError(t, err)
+	require.Len(t, hashA, 64)
+	hashB, err := client.HashS3Prefix("b/")
+	require.NoError(t, err)
+	require.Equal(t, hashA, hashB)
+
+	// the listing order doesn't matter
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		fake.listObjectsV2(r.Params.(*s3.ListObjectsV2Input), r.Data.(*s3.ListObjectsV2Output))
+		contents := r.Data.(*s3.ListObjectsV2Output).Contents
+		for i, j := 0, len(contents)-1; i < j; i, j = i+1, j-1 {
+			contents[i], contents[j] = contents[j], contents[i]
+		}
+		return true
+	}
+	hash, err := client.HashS3Prefix("a/")
+	require.NoError(t, err)
+	require.Equal(t, hashA, hash)
+	delete(fake.hooks, "ListObjectsV2")
+
+	fake.putObject("b/z", []byte("changed"))
+	hashB, err = client.HashS3Prefix("b/")
+	require.NoError(t, err)
+	require.NotEqual(t, hashA, hashB)
+
+	fake.putObject("a/w", []byte("w"))
+	hash, err = client.HashS3Prefix("a/")
+	require.NoError(t, err)
+	require.NotEqual(t, hashA, hash)
+}
+
+func TestIsMultipartETag(t *testing.T) {
+	require.True(t, IsMultipartETag("abc-3"))
+	require.True(t, IsMultipartETag(`"d41d8cd98f00b204e9800998ecf8427e-12"`))
+	require.False(t, IsMultipartETag(`"d41d8cd98f00b204e9800998ecf8427e"`))
+	require.False(t, IsMultipartETag("abc-"))
+	require.False(t, IsMultipartETag("abc-0"))
+	require.False(t, IsMultipartETag("-3"))
+	require.False(t, IsMultipartETag(""))
+}
+
+func TestDiffS3PrefixesMultipart(t *testing.T) {
+	client, fake := newFakeS3Client()
+	setObject := func(key string, data string, etag string, contentHash string) {
+		fake.putObject(key, []byte(data))
+		obj, _ := fake.object(key)
+		obj.etag = etag
+		if contentHash != "" {
+			obj.metadata = map[string]*string{"Content-Sha256": aws.String(contentHash)}
+		}
+	}
+	// the same content uploaded with different part sizes
+	setObject("a/same", "data", `"abc-3"`, "hash1")
+	setObject("b/same", "data", `"def-2"`, "hash1")
+	setObject("a/changed", "data", `"abc-3"`, "hash1")
+	setObject("b/changed", "atad", `"abc-3"`, "hash2")
+	setObject("a/no-hash", "data", `"abc-3"`, "")
+	setObject("b/no-hash", "data", `"0123456789abcdef0123456789abcdef"`, "")
+	setObject("a/resized", "data", `"abc-3"`, "hash1")
+	setObject("b/resized", "longer data", `"abc-3"`, "hash1")
+
+	numHeads := fake.numCalls("HeadObject")
+	diff, err := client.DiffS3Prefixes("a/", "b/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"changed", "resized"}, diff.Different)
+	require.Empty(t, diff.OnlyInA)
+	require.Empty(t, diff.OnlyInB)
+	// only the multipart objects with the same size are compared by hash
+	require.Equal(t, numHeads+6, fake.numCalls("HeadObject"))
+}
+
+func TestPresignedMultipartUpload(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	upload, err := client.CreatePresignedMultipartUpload("uploads/video", 3, time.Hour)
+	require.NoError(t, err)
+	require.Equal(t, "uploads/video", upload.Key)
+	require.Len(t, upload.PartURLs, 3)
+	require.Contains(t, fake.multipartUploads, upload.UploadID)
+	for i, partURL := range upload.PartURLs {
+		parsed, err := url.Parse(partURL)
+		require.NoError(t, err)
+		require.Equal(t, "/uploads/video", strings.TrimPrefix(parsed.Path, "/"+_testBucket))
+		require.Equal(t, strconv.Itoa(i+1), parsed.Query().Get("partNumber"))
+		require.Equal(t, upload.UploadID, parsed.Query().Get("uploadId"))
+		require.NotEmpty(t, parsed.Query().Get("X-Amz-Signature"))
+	}
+	require.Zero(t, fake.numCalls("UploadPart"))
+
+	// the browser uploads the parts with the presigned URLs
+	var etags []string
+	for i, data := range []string{"aaa", "bbb", "c"} {
+		fake.multipartUploads[upload.UploadID].parts[int64(i+1)] = []byte(data)
+		etags = append(etags, fmt.Sprintf(`"etag-%d"`, i+1))
+	}
+
+	require.NoError(t, client.CompletePresignedMultipartUpload(upload.Key, upload.UploadID, etags))
+	input := fake.lastInput("CompleteMultipartUpload").(*s3.CompleteMultipartUploadInput)
+	require.Len(t, input.MultipartUpload.Parts, 3)
+	require.Equal(t, int64(3), *input.MultipartUpload.Parts[2].PartNumber)
+	require.Equal(t, `"etag-3"`, *input.MultipartUpload.Parts[2].ETag)
+	obj, ok := fake.object("uploads/video")
+	require.True(t, ok)
+	require.Equal(t, []byte("aaabbbc"), obj.data)
+
+	_, err = client.CreatePresignedMultipartUpload("uploads/video", 0, time.Hour)
+	require.Error(t, err)
+	require.Error(t, client.CompletePresignedMultipartUpload("uploads/video", "missing-upload", etags))
+}
+
+func TestUploadMultipartToS3(t *testing.T) {
+	defer func(delay time.Duration) { _multipartPartRetryInitialDelay = delay }(_multipartPartRetryInitialDelay)
+	_multipartPartRetryInitialDelay = time.Millisecond
+
+	client, fake := newFakeS3Client()
+	data := []byte("aaaabbbbccccdd")
+
+	// part 2 fails twice before succeeding
+	part2Failures := 0
+	fake.hooks["UploadPart"] = func(r *request.Request) bool {
+		if *r.Params.(*s3.UploadPartInput).PartNumber == 2 && part2Failures < 2 {
+			part2Failures++
+			r.Error = fakeS3Err("RequestTimeout", http.StatusBadRequest)
+			return true
+		}
+		return false
+	}
+
+	options := MultipartUploadOptions{PartSize: 4, Concurrency: 2, MaxPartRetries: 2}
+	require.NoError(t, client.UploadMultipartToS3(bytes.NewReader(data), "object", options))
+
+	obj, ok := fake.object("object")
+	require.True(t, ok)
+	require.Equal(t, data, obj.data)
+	require.Equal(t, 4+2, fake.numCalls("UploadPart"))
+	require.Equal(t, 0, fake.numCalls("AbortMultipartUpload"))
+
+	part2Failures = 0
+	options.MaxPartRetries = 1
+	err := client.UploadMultipartToS3(bytes.NewReader(data), "failed", options)
+	require.True(t, CheckErrCode(err, "RequestTimeout"))
+	require.Equal(t, 1, fake.numCalls("AbortMultipartUpload"))
+	_, ok = fake.object("failed")
+	require.False(t, ok)
+	require.Empty(t, fake.multipartUploads)
+}
+
+func TestNewS3ReadSeeker(t *testing.T) {
+	client, fake := newFakeS3Client()
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	fake.putObject("object", data)
+
+	readSeeker, size, err := client.NewS3ReadSeeker("object")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), size)
+
+	offset, err := readSeeker.Seek(500, io.SeekStart)
+	require.NoError(t, err)
+	require.Equal(t, int64(500), offset)
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(readSeeker, buf)
+	require.NoError(t, err)
+	require.Equal(t, data[500:600], buf)
+	require.Equal(t, "bytes=500-599", aws.StringValue(fake.lastInput("GetObject").(*s3.GetObjectInput).Range))
+
+	_, err = readSeeker.Seek(-10, io.SeekCurrent)
+	require.NoError(t, err)
+	_, err = io.ReadFull(readSeeker, buf[:10])
+	require.NoError(t, err)
+	require.Equal(t, data[590:600], buf[:10])
+
+	_, err = readSeeker.Seek(-50, io.SeekEnd)
+	require.NoError(t, err)
+	rest, err := ioutil.ReadAll(readSeeker)
+	require.NoError(t, err)
+	require.Equal(t, data[950:], rest)
+
+	_, err = readSeeker.Seek(-1, io.SeekStart)
+	require.Error(t, err)
+}
+
+func TestTagS3Prefix(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("dir/a", []byte("a"))
+	fake.putObject("dir/b", []byte("b"))
+	fake.putObject("other", []byte("other"))
+	require.NoError(t, client.PutS3ObjectTags("dir/a", map[string]string{"owner": "team"}))
+
+	require.NoError(t, client.TagS3Prefix("dir/", map[string]string{"cost-center": "ml"}))
+	tags, err := client.GetS3ObjectTags("dir/a")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"owner": "team", "cost-center": "ml"}, tags)
+	tags, err = client.GetS3ObjectTags("dir/b")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cost-center": "ml"}, tags)
+	tags, err = client.GetS3ObjectTags("other")
+	require.NoError(t, err)
+	require.Empty(t, tags)
+
+	require.NoError(t, client.TagS3PrefixWithOptions("dir/", map[string]string{"project": "x"}, true))
+	tags, err = client.GetS3ObjectTags("dir/a")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"project": "x"}, tags)
+}
+
+func TestConcatS3Objects(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("part-0", []byte("first,"))
+	fake.putObject("part-1", []byte("second,"))
+	fake.putObject("part-2", []byte("third"))
+	srcKeys := []string{"part-0", "part-1", "part-2"}
+
+	// the parts are smaller than the multipart minimum
+	require.NoError(t, client.ConcatS3Objects(srcKeys, "small"))
+	obj, _ := fake.object("small")
+	require.Equal(t, []byte("first,second,third"), obj.data)
+	require.Equal(t, 0, fake.numCalls("UploadPartCopy"))
+
+	defer func(size int64) { _s3MinPartSize = size }(_s3MinPartSize)
+	_s3MinPartSize = 6
+
+	require.NoError(t, client.ConcatS3Objects([]string{"part-2", "part-1", "part-0"}, "large"))
+	obj, _ = fake.object("large")
+	require.Equal(t, []byte("thirdsecond,first,"), obj.data)
+	require.Equal(t, 0, fake.numCalls("UploadPartCopy"))
+
+	numGetObjectCalls := fake.numCalls("GetObject")
+	require.NoError(t, client.ConcatS3Objects(srcKeys, "large"))
+	obj, _ = fake.object("large")
+	require.Equal(t, []byte("first,second,third"), obj.data)
+	require.Equal(t, 3, fake.numCalls("UploadPartCopy"))
+	require.Equal(t, numGetObjectCalls, fake.numCalls("GetObject"))
+
+	err := client.ConcatS3Objects([]string{"part-0", "missing"}, "failed")
+	require.True(t, IsNoSuchKeyErr(err))
+
+	// sources larger than a copied part are copied in ranges
+	defer func(size int64) { _s3MaxCopyPartSize = size }(_s3MaxCopyPartSize)
+	_s3MaxCopyPartSize = 4
+	require.NoError(t, client.ConcatS3Objects(srcKeys, "ranged"))
+	obj, _ = fake.object("ranged")
+	require.Equal(t, []byte("first,second,third"), obj.data)
+	require.Equal(t, 3+2+2+2, fake.numCalls("UploadPartCopy"))
+}
+
+func TestCopySourceRanges(t *testing.T) {
+	require.Equal(t, []*string{nil}, copySourceRanges(_s3MaxCopyPartSize))
+
+	var ranges []string
+	for _, copySourceRange := range copySourceRanges(_s3MaxCopyPartSize + 1) {
+		ranges = append(ranges, aws.StringValue(copySourceRange))
+	}
+	half := (_s3MaxCopyPartSize + 2) / 2
+	require.Equal(t, []string{
+		fmt.Sprintf("bytes=0-%d", half-1),
+		fmt.Sprintf("bytes=%d-%d", half, _s3MaxCopyPartSize),
+	}, ranges)
+}
+
+func TestReadJSONRawFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("config.json", []byte(`{"name": "iris", "replicas": 3, "tracker": {"model_type": "classification"}}`))
+
+	fields, err := client.ReadJSONRawFromS3("config.json")
+	require.NoError(t, err)
+	require.Len(t, fields, 3)
+
+	var tracker struct {
+		ModelType string `json:"model_type"`
+	}
+	require.NoError(t, json.Unmarshal(fields["tracker"], &tracker))
+	require.Equal(t, "classification", tracker.ModelType)
+
+	fake.putObject("array.json", []byte(`[1, 2]`))
+	_, err = client.ReadJSONRawFromS3("array.json")
+	require.Error(t, err)
+}
+
+func TestReadValidatedJSONFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("config.json", []byte(`{"name": "iris", "model_type": "unknown"}`))
+
+	type config struct {
+		Name      string `json:"name"`
+		ModelType string `json:"model_type"`
+	}
+	validate := func(obj interface{}) error {
+		modelType := obj.(*config).ModelType
+		if modelType != "classification" && modelType != "regression" {
+			return fmt.Errorf("invalid model_type %q", modelType)
+		}
+		return nil
+	}
+
+	var cfg config
+	err := client.ReadValidatedJSONFromS3(&cfg, "config.json", validate)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "config.json")
+	require.Contains(t, err.Error(), `invalid model_type "unknown"`)
+
+	fake.putObject("valid.json", []byte(`{"name": "iris", "model_type": "regression"}`))
+	require.NoError(t, client.ReadValidatedJSONFromS3(&cfg, "valid.json", validate))
+	require.Equal(t, "iris", cfg.Name)
+}
+
+func TestClassifyS3Path(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("dir/file", []byte("data"))
+	fake.putObject("dir/sub/file", []byte("data"))
+	fake.putObject("dir-sibling", []byte("data"))
+	fake.putObject("marker/", []byte{})
+
+	for _, tc := range []struct {
+		key          string
+		expected     S3PathKind
+		expectedHead bool
+	}{
+		{key: "dir/file", expected: FileS3PathKind, expectedHead: true},
+		{key: "dir", expected: DirS3PathKind},
+		{key: "dir/", expected: DirS3PathKind},
+		{key: "dir/sub", expected: DirS3PathKind},
+		{key: "marker", expected: DirS3PathKind},
+		{key: "di", expected: NotFoundS3PathKind, expectedHead: true},
+		{key: "missing/", expected: NotFoundS3PathKind},
+	} {
+		numListCalls, numHeadCalls := fake.numCalls("ListObjectsV2"), fake.numCalls("HeadObject")
+		kind, err := client.ClassifyS3Path(client.S3Path(tc.key))
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, kind, tc.key)
+		require.Equal(t, numListCalls+1, fake.numCalls("ListObjectsV2"), tc.key)
+		require.Equal(t, tc.expectedHead, fake.numCalls("HeadObject") > numHeadCalls, tc.key)
+	}
+}
+
+func TestUploadInline(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	require.NoError(t, client.UploadBytesToS3([]byte("{}"), "attachment.json"))
+	require.Equal(t, "attachment", aws.StringValue(fake.lastInput("PutObject").(*s3.PutObjectInput).ContentDisposition))
+
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("{}"), "inline.json", UploadOptions{ContentType: "application/json", ContentDisposition: "inline"}))
+	require.Equal(t, "inline", aws.StringValue(fake.lastInput("PutObject").(*s3.PutObjectInput).ContentDisposition))
+}
+
+func TestResumeMultipartUploadToS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	data := []byte("aaaabbbbccccdd")
+	options := MultipartUploadOptions{PartSize: 4, Concurrency: 2}
+
+	// the first process uploads parts 1 and 2 and is killed
+	uploadID, err := client.CreateS3MultipartUpload("object")
+	require.NoError(t, err)
+	fake.hooks["UploadPart"] = func(r *request.Request) bool {
+		if *r.Params.(*s3.UploadPartInput).PartNumber > 2 {
+			r.Error = fakeS3Err("RequestTimeout", http.StatusBadRequest)
+			return true
+		}
+		return false
+	}
+	require.Error(t, client.ResumeMultipartUploadToS3(bytes.NewReader(data), "object", uploadID, nil, options))
+	delete(fake.hooks, "UploadPart")
+
+	uploads, err := client.ListS3MultipartUploads("")
+	require.NoError(t, err)
+	require.Len(t, uploads, 1)
+	require.Equal(t, uploadID, *uploads[0].UploadId)
+
+	completedParts, err := client.ListS3MultipartUploadParts("object", uploadID)
+	require.NoError(t, err)
+	require.Len(t, completedParts, 2)
+
+	numUploadPartCalls := fake.numCalls("UploadPart")
+	require.NoError(t, client.ResumeMultipartUploadToS3(bytes.NewReader(data), "object", uploadID, completedParts, options))
+	require.Equal(t, numUploadPartCalls+2, fake.numCalls("UploadPart"))
+	obj, _ := fake.object("object")
+	require.Equal(t, data, obj.data)
+	require.Empty(t, fake.multipartUploads)
+}
+
+func TestAbortStaleMultipartUploads(t *testing.T) {
+	client, fake := newFakeS3Client()
+	staleID, err := client.CreateS3MultipartUpload("dir/stale")
+	require.NoError(t, err)
+	recentID, err := client.CreateS3MultipartUpload("dir/recent")
+	require.NoError(t, err)
+	otherID, err := client.CreateS3MultipartUpload("other/stale")
+	require.NoError(t, err)
+	fake.multipartUploads[staleID].initiated = time.Now().Add(-48 * time.Hour)
+	fake.multipartUploads[otherID].initiated = time.Now().Add(-48 * time.Hour)
+
+	uploads, err := client.ListS3MultipartUploads("dir/")
+	require.NoError(t, err)
+	require.Len(t, uploads, 2)
+
+	require.NoError(t, client.AbortStaleMultipartUploads("dir/", 24*time.Hour))
+	require.Equal(t, 1, fake.numCalls("AbortMultipartUpload"))
+	require.Contains(t, fake.multipartUploads, recentID)
+	require.Contains(t, fake.multipartUploads, otherID)
+	require.NotContains(t, fake.multipartUploads, staleID)
+}
+
+func TestGetBucketRegionRetries(t *testing.T) {
+	defer func(lookup func(string) (string, error), delay time.Duration) {
+		_lookupBucketRegion = lookup
+		_s3ThrottleInitialDelay = delay
+	}(_lookupBucketRegion, _s3ThrottleInitialDelay)
+	_s3ThrottleInitialDelay = time.Millisecond
+
+	lookups := 0
+	_lookupBucketRegion = func(bucket string) (string, error) {
+		lookups++
+		if lookups <= 2 {
+			return "", fakeS3Err("SlowDown", http.StatusServiceUnavailable)
+		}
+		return "eu-west-1", nil
+	}
+	region, err := GetBucketRegion(_testBucket)
+	require.NoError(t, err)
+	require.Equal(t, "eu-west-1", region)
+	require.Equal(t, 3, lookups)
+
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+		lookups = 0
+		_lookupBucketRegion = func(bucket string) (string, error) {
+			lookups++
+			return "", fakeS3Err(http.StatusText(status), status)
+		}
+		_, err = GetBucketRegion(_testBucket)
+		require.Equal(t, ErrBucketInaccessible, errors.Cause(err).(Error).Kind)
+		require.Equal(t, 1, lookups)
+	}
+
+	lookups = 0
+	_lookupBucketRegion = func(bucket string) (string, error) {
+		lookups++
+		return "", fakeS3Err("Throttling", http.StatusBadRequest)
+	}
+	_, err = GetBucketRegion(_testBucket)
+	require.Equal(t, ErrBucketInaccessible, errors.Cause(err).(Error).Kind)
+	require.Equal(t, _s3ThrottleMaxRetries+1, lookups)
+}
+
+func TestS3PrefixesExist(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("models/a/1.pb", []byte("1"))
+	fake.putObject("models/a/2.pb", []byte("2"))
+	fake.putObject("models/c/1.pb", []byte("1"))
+
+	exists, err := client.S3PrefixesExist("models/a/", "models/b/", "models/c/", "models/")
+	require.NoError(t, err)
+	require.Equal(t, map[string]bool{"models/a/": true, "models/b/": false, "models/c/": true, "models/": true}, exists)
+	require.Equal(t, int64(1), aws.Int64Value(fake.lastInput("ListObjectsV2").(*s3.ListObjectsV2Input).MaxKeys))
+
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		if *r.Params.(*s3.ListObjectsV2Input).Prefix == "models/b/" {
+			r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+	_, err = client.S3PrefixesExist("models/a/", "models/b/")
+	require.True(t, CheckErrCode(err, "AccessDenied"))
+}
+
+func TestVerifyS3Access(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.VerifyS3Access(_testBucket))
+	input := fake.lastInput("ListObjectsV2").(*s3.ListObjectsV2Input)
+	require.Equal(t, int64(0), aws.Int64Value(input.MaxKeys))
+
+	err := client.VerifyS3Access("")
+	require.Equal(t, ErrBucketNotConfigured, errors.Cause(err).(Error).Kind)
+
+	err = client.VerifyS3Access("missing-bucket")
+	require.Equal(t, ErrS3BucketNotFound, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "missing-bucket")
+
+	fake.hooks["HeadBucket"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("Forbidden", http.StatusForbidden)
+		return true
+	}
+	err = client.VerifyS3Access(_testBucket)
+	require.Equal(t, ErrS3AccessDenied, errors.Cause(err).(Error).Kind)
+
+	// HeadBucket is allowed, but listing is not
+	delete(fake.hooks, "HeadBucket")
+	fake.hooks["ListObjectsV2"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+	err = client.VerifyS3Access(_testBucket)
+	require.Equal(t, ErrS3AccessDenied, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "list the bucket")
+
+	delete(fake.hooks, "ListObjectsV2")
+	fake.hooks["HeadBucket"] = func(r *request.Request) bool {
+		r.Error = awserr.New("RequestError", "send request failed", errors.New("dial tcp: no such host"))
+		return true
+	}
+	err = client.VerifyS3Access(_testBucket)
+	require.Equal(t, ErrS3Unreachable, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "no such host")
+
+	fake.hooks["HeadBucket"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("InternalError", http.StatusInternalServerError)
+		return true
+	}
+	err = client.VerifyS3Access(_testBucket)
+	require.Error(t, err)
+	_, isTyped := errors.Cause(err).(Error)
+	require.False(t, isTyped)
+}
+
+func TestS3Logger(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("dir/file", []byte("data"))
+
+	var logs []S3OperationLog
+	var mux sync.Mutex
+	client.S3Logger = func(log S3OperationLog) {
+		mux.Lock()
+		defer mux.Unlock()
+		logs = append(logs, log)
+	}
+
+	_, err := client.ReadBytesFromS3("dir/file")
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	require.Equal(t, "GetObject", logs[0].Operation)
+	require.Equal(t, _testBucket, logs[0].Bucket)
+	require.Equal(t, "dir/file", logs[0].Key)
+	require.True(t, logs[0].Duration > 0)
+	require.NoError(t, logs[0].Err)
+
+	_, err = client.ReadBytesFromS3("missing")
+	require.Error(t, err)
+	require.Len(t, logs, 2)
+	require.Equal(t, "missing", logs[1].Key)
+	require.Error(t, logs[1].Err)
+
+	_, err = client.ListPrefix("dir/", 10)
+	require.NoError(t, err)
+	require.Equal(t, "ListObjectsV2", logs[2].Operation)
+	require.Empty(t, logs[2].Key)
+}
+
+type fakeS3MetricsRecorder struct {
+	mux        sync.Mutex
+	operations []string
+	bytes      map[string]int64
+	failures   map[string]int
+}
+
+func (r *fakeS3MetricsRecorder) RecordS3Operation(operation string, bytes int64, success bool) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.operations = append(r.operations, operation)
+	r.bytes[operation] += bytes
+	if !success {
+		r.failures[operation]++
+	}
+}
+
+func TestS3Metrics(t *testing.T) {
+	client, _ := newFakeS3Client()
+	recorder := &fakeS3MetricsRecorder{bytes: map[string]int64{}, failures: map[string]int{}}
+	client.S3Metrics = recorder
+
+	require.NoError(t, client.UploadBytesToS3([]byte("0123456789"), "file"))
+	require.Equal(t, []string{"PutObject"}, recorder.operations)
+	require.Equal(t, int64(10), recorder.bytes["PutObject"])
+	require.Zero(t, recorder.failures["PutObject"])
+
+	_, err := client.ReadBytesFromS3("file")
+	require.NoError(t, err)
+	require.Equal(t, int64(10), recorder.bytes["GetObject"])
+
+	_, err = client.ReadBytesFromS3("missing")
+	require.Error(t, err)
+	require.Equal(t, int64(10), recorder.bytes["GetObject"])
+	require.Equal(t, 1, recorder.failures["GetObject"])
+
+	var _ S3MetricsRecorder = NoopS3MetricsRecorder{}
+}
+
+func TestReadBytesFromS3Limited(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("small", []byte("0123456789"))
+	fake.putObject("large", bytes.Repeat([]byte("x"), 100))
+
+	data, err := client.ReadBytesFromS3Limited("small", 10)
+	require.NoError(t, err)
+	require.Equal(t, []byte("0123456789"), data)
+
+	_, err = client.ReadBytesFromS3Limited("large", 10)
+	require.Equal(t, ErrS3ObjectTooLarge, errors.Cause(err).(Error).Kind)
+
+	// the content length is not trusted
+	var body *countingReader
+	fake.hooks["GetObject"] = func(r *request.Request) bool {
+		body = &countingReader{Reader: bytes.NewReader(bytes.Repeat([]byte("x"), 1000))}
+		output := r.Data.(*s3.GetObjectOutput)
+		output.Body = ioutil.NopCloser(body)
+		output.ContentLength = aws.Int64(5)
+		return true
+	}
+	_, err = client.ReadBytesFromS3Limited("small", 10)
+	require.Equal(t, ErrS3ObjectTooLarge, errors.Cause(err).(Error).Kind)
+	require.Contains(t, err.Error(), "small")
+	require.Equal(t, int64(11), body.n)
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func TestListBuckets(t *testing.T) {
+	defer func(lookup func(string) (string, error)) { _lookupBucketRegion = lookup }(_lookupBucketRegion)
+	_lookupBucketRegion = func(bucket string) (string, error) {
+		return map[string]string{"models": "us-west-2", "logs": "eu-central-1"}[bucket], nil
+	}
+
+	client, fake := newFakeS3Client()
+	client.Bucket = ""
+	fake.hooks["ListBuckets"] = func(r *request.Request) bool {
+		r.Data.(*s3.ListBucketsOutput).Buckets = []*s3.Bucket{
+			{Name: aws.String("models")},
+			{Name: aws.String("logs")},
+		}
+		return true
+	}
+
+	buckets, err := client.ListBuckets()
+	require.NoError(t, err)
+	require.Equal(t, []string{"models", "logs"}, buckets)
+
+	regions, err := client.ListBucketsWithRegions()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"models": "us-west-2", "logs": "eu-central-1"}, regions)
+
+	fake.hooks["ListBuckets"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+	_, err = client.ListBuckets()
+	require.Error(t, err)
+}
+
+func TestReadGzipRangeFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	gzipBlock := func(data string) []byte {
+		var buf bytes.Buffer
+		gzipWriter := gzip.NewWriter(&buf)
+		_, err := gzipWriter.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, gzipWriter.Close())
+		return buf.Bytes()
+	}
+	header := []byte("HEADER")
+	block1 := gzipBlock("first block")
+	block2 := gzipBlock("second block")
+	fake.putObject("blocks", bytes.Join([][]byte{header, block1, block2}, nil))
+
+	start := int64(len(header) + len(block1))
+	end := start + int64(len(block2)) - 1
+	data, err := client.ReadGzipRangeFromS3("blocks", start, end)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second block"), data)
+
+	data, err = client.ReadGzipRangeFromS3("blocks", int64(len(header)), end)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first blocksecond block"), data)
+
+	// the range cuts off the end of the block
+	_, err = client.ReadGzipRangeFromS3("blocks", start, end-4)
+	require.Error(t, err)
+
+	// the range doesn't start at a block
+	_, err = client.ReadGzipRangeFromS3("blocks", 0, end)
+	require.Error(t, err)
+
+	_, err = client.ReadGzipRangeFromS3("blocks", 10, 5)
+	require.Error(t, err)
+}
+
+func TestUploadBytesToS3IfDifferent(t *testing.T) {
+	client, fake := newFakeS3Client()
+	data := []byte("model")
+	metadata := map[string]string{"version": "1", "owner": "team"}
+
+	uploaded, err := client.UploadBytesToS3IfDifferent(data, "model", metadata)
+	require.NoError(t, err)
+	require.True(t, uploaded)
+	obj, _ := fake.object("model")
+	require.Equal(t, "1", aws.StringValue(obj.metadata["Version"]))
+	require.Equal(t, ContentHash(data), aws.StringValue(obj.metadata["Content-Sha256"]))
+
+	numPuts := fake.numCalls("PutObject")
+	uploaded, err = client.UploadBytesToS3IfDifferent(data, "model", map[string]string{"version": "1", "owner": "team"})
+	require.NoError(t, err)
+	require.False(t, uploaded)
+	require.Equal(t, numPuts, fake.numCalls("PutObject"))
+
+	// the content matches, but the metadata doesn't
+	for _, changedMetadata := range []map[string]string{
+		{"version": "2", "owner": "team"},
+		{"version": "1"},
+		{"version": "1", "owner": "team", "stage": "prod"},
+	} {
+		uploaded, err = client.UploadBytesToS3IfDifferent(data, "model", changedMetadata)
+		require.NoError(t, err)
+		require.True(t, uploaded, changedMetadata)
+	}
+	obj, _ = fake.object("model")
+	require.Equal(t, "prod", aws.StringValue(obj.metadata["Stage"]))
+
+	uploaded, err = client.UploadBytesToS3IfDifferent([]byte("new model"), "model", map[string]string{"version": "1", "owner": "team", "stage": "prod"})
+	require.NoError(t, err)
+	require.True(t, uploaded)
+}
+
+func TestWaitForBucket(t *testing.T) {
+	defer func(delay time.Duration) { _waitForBucketInitialDelay = delay }(_waitForBucketInitialDelay)
+	_waitForBucketInitialDelay = time.Millisecond
+
+	client, fake := newFakeS3Client()
+	numNotFound := 0
+	fake.hooks["HeadBucket"] = func(r *request.Request) bool {
+		if numNotFound < 2 {
+			numNotFound++
+			r.Error = fakeS3Err("NotFound", http.StatusNotFound)
+			return true
+		}
+		return false
+	}
+
+	numCalls := fake.numCalls("HeadBucket")
+	require.NoError(t, client.WaitForBucket(_testBucket, time.Second))
+	require.Equal(t, numCalls+3, fake.numCalls("HeadBucket"))
+
+	err := client.WaitForBucket("missing-bucket", 20*time.Millisecond)
+	require.Equal(t, ErrS3BucketNotFound, errors.Cause(err).(Error).Kind)
+
+	fake.hooks["HeadBucket"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("Forbidden", http.StatusForbidden)
+		return true
+	}
+	numCalls = fake.numCalls("HeadBucket")
+	require.Error(t, client.WaitForBucket(_testBucket, time.Second))
+	require.Equal(t, numCalls+1, fake.numCalls("HeadBucket"))
+}
+
+func TestReadIntoBuffer(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("long", []byte("a longer object"))
+	fake.putObject("short", []byte("short"))
+
+	var buf bytes.Buffer
+	require.NoError(t, client.ReadIntoBuffer("long", &buf))
+	require.Equal(t, "a longer object", buf.String())
+
+	require.NoError(t, client.ReadIntoBuffer("short", &buf))
+	require.Equal(t, "short", buf.String())
+
+	require.Error(t, client.ReadIntoBuffer("missing", &buf))
+}
+
+func TestReadEmptyObjectFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	fake.putObject("empty", []byte{})
+
+	data, err := client.ReadBytesFromS3("empty")
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	require.Empty(t, data)
+
+	data, err = client.ResumableReadBytesFromS3("empty")
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	require.Empty(t, data)
+
+	data, err = client.ReadBytesFromS3Limited("empty", 10)
+	require.NoError(t, err)
+	require.NotNil(t, data)
+
+	str, err := client.ReadStringFromS3("empty")
+	require.NoError(t, err)
+	require.Equal(t, "", str)
+
+	_, err = client.ReadBytesFromS3("missing")
+	require.True(t, IsNoSuchKeyErr(err))
+	_, err = client.ReadStringFromS3("missing")
+	require.True(t, IsNoSuchKeyErr(err))
+
+	_, err = client.ReadRequiredBytesFromS3("empty")
+	require.Equal(t, ErrS3ObjectEmpty, errors.Cause(err).(Error).Kind)
+	_, err = client.ReadRequiredBytesFromS3("missing")
+	require.True(t, IsNoSuchKeyErr(err))
+
+	fake.putObject("file", []byte("data"))
+	data, err = client.ReadRequiredBytesFromS3("file")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+}
+
+func TestBucketPolicy(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	policy, err := client.GetBucketPolicy(_testBucket)
+	require.NoError(t, err)
+	require.Empty(t, policy)
+
+	err = client.PutBucketPolicy(_testBucket, `{"Version": "2012-10-17", "Statement": [`)
+	require.Equal(t, ErrInvalidBucketPolicy, errors.Cause(err).(Error).Kind)
+	require.Zero(t, fake.numCalls("PutBucketPolicy"))
+
+	validPolicy := `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::cortex-test/*"}]}`
+	require.NoError(t, client.PutBucketPolicy(_testBucket, validPolicy))
+	policy, err = client.GetBucketPolicy(_testBucket)
+	require.NoError(t, err)
+	require.Equal(t, validPolicy, policy)
+
+	require.NoError(t, client.DeleteBucketPolicy(_testBucket))
+	policy, err = client.GetBucketPolicy(_testBucket)
+	require.NoError(t, err)
+	require.Empty(t, policy)
+}
+
+func TestNewS3Writer(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	writer := client.NewS3Writer("object")
+	for _, chunk := range []string{"first ", "second ", "third"} {
+		_, err := io.WriteString(writer, chunk)
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+	require.NoError(t, writer.Close())
+	obj, ok := fake.object("object")
+	require.True(t, ok)
+	require.Equal(t, []byte("first second third"), obj.data)
+
+	fake.hooks["PutObject"] = func(r *request.Request) bool {
+		r.Error = fakeS3Err("AccessDenied", http.StatusForbidden)
+		return true
+	}
+	writer = client.NewS3Writer("failed")
+	_, err := io.WriteString(writer, "data")
+	require.NoError(t, err)
+	err = writer.Close()
+	require.True(t, CheckErrCode(err, "AccessDenied"))
+	_, ok = fake.object("failed")
+	require.False(t, ok)
+}
+
+func TestUploadKeyValidation(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	require.NoError(t, client.UploadBytesToS3([]byte("data"), "/foo//bar"))
+	_, ok := fake.object("/foo//bar")
+	require.True(t, ok)
+
+	client.UploadKeyValidation = UploadKeyValidationReject
+	err := client.UploadBytesToS3([]byte("data"), "/foo//bar")
+	require.Equal(t, ErrConfusingS3Key, errors.Cause(err).(Error).Kind)
+	writer := client.NewS3Writer("foo//bar")
+	_, err = writer.Write([]byte("data"))
+	require.Equal(t, ErrConfusingS3Key, errors.Cause(err).(Error).Kind)
+	require.Equal(t, ErrConfusingS3Key, errors.Cause(writer.Close()).(Error).Kind)
+	require.NoError(t, client.UploadBytesToS3([]byte("data"), "foo/bar/"))
+
+	client, fake = newFakeS3Client()
+	client.UploadKeyValidation = UploadKeyValidationNormalize
+	require.NoError(t, client.UploadBytesToS3([]byte("data"), "/foo//bar"))
+	require.NoError(t, client.UploadJSONLinesToS3([]interface{}{1}, "//lines"))
+	require.NoError(t, client.UploadMultipartToS3(bytes.NewReader([]byte("data")), "/multipart", MultipartUploadOptions{}))
+	require.Equal(t, []string{"foo/bar", "lines", "multipart"}, fake.sortedKeys())
+
+	require.NoError(t, client.CopyS3ToS3("foo/bar", "/copied//key"))
+	require.NoError(t, client.MoveS3File("copied/key", "//moved", false))
+	defer func(size int64) { _s3MinPartSize = size }(_s3MinPartSize)
+	_s3MinPartSize = 4
+	require.NoError(t, client.ConcatS3Objects([]string{"foo/bar", "moved"}, "/concat//key"))
+	require.Equal(t, 2, fake.numCalls("UploadPartCopy"))
+	require.Equal(t, []string{"concat/key", "foo/bar", "lines", "moved", "multipart"}, fake.sortedKeys())
+	obj, _ := fake.object("concat/key")
+	require.Equal(t, []byte("datadata"), obj.data)
+
+	client.UploadKeyValidation = UploadKeyValidationReject
+	require.Equal(t, ErrConfusingS3Key, errors.Cause(client.CopyS3ToS3("foo/bar", "/copied")).(Error).Kind)
+	require.Equal(t, ErrConfusingS3Key, errors.Cause(client.MoveS3File("foo/bar", "a//b", true)).(Error).Kind)
+	require.Equal(t, ErrConfusingS3Key, errors.Cause(client.ConcatS3Objects([]string{"foo/bar"}, "/concat")).(Error).Kind)
+}
+
+func TestMsgpackStreamRoundTrip(t *testing.T) {
+	client, _ := newFakeS3Client()
+
+	type record struct {
+		ID    int
+		Name  string
+		Score float64
+	}
+
+	writer := client.NewS3MsgpackStreamWriter("records.msgpack")
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, writer.Append(record{ID: i, Name: fmt.Sprintf("record-%d", i), Score: float64(i) / 2}))
+	}
+	require.NoError(t, writer.Close())
+
+	count := 0
+	err := client.ReadMsgpackStreamFromS3("records.msgpack", func(data []byte) error {
+		var r record
+		if err := msgpack.Unmarshal(data, &r); err != nil {
+			return err
+		}
+		require.Equal(t, record{ID: count, Name: fmt.Sprintf("record-%d", count), Score: float64(count) / 2}, r)
+		count++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10000, count)
+}
+
+func TestReadBytesAndMetadataFromS3(t *testing.T) {
+	client, fake := newFakeS3Client()
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("data"), "object", UploadOptions{ContentType: "text/plain", StoreContentHash: true}))
+	obj, _ := fake.object("object")
+
+	data, metadata, err := client.ReadBytesAndMetadataFromS3("object")
+	require.NoError(t, err)
+	require.Equal(t, []byte("data"), data)
+	require.Equal(t, &S3ObjectMetadata{
+		Key:          "object",
+		Size:         4,
+		LastModified: obj.lastModified,
+		ETag:         obj.etag,
+		ContentType:  "text/plain",
+		Metadata:     obj.metadata,
+	}, metadata)
+	require.Equal(t, ContentHash(data), metadataValue(metadata.Metadata, ContentHashMetadataKey))
+	require.Equal(t, 1, fake.numCalls("GetObject"))
+	require.Equal(t, 0, fake.numCalls("HeadObject"))
+}
+
+func TestExtractS3aPathPrefixes(t *testing.T) {
+	client, _ := newFakeS3Client()
+
+	prefixes, err := client.ExtractS3aPathPrefixes("s3a://"+_testBucket+"/dir/file", "s3a://"+_testBucket+"/dir/")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/file", "dir/"}, prefixes)
+
+	_, err = client.ExtractS3aPathPrefixes("s3a://"+_testBucket+"/dir/file", "s3a://other-bucket/dir/file")
+	require.Error(t, err)
+
+	_, err = client.ExtractS3aPathPrefixes("s3://" + _testBucket + "/dir/file")
+	require.Equal(t, ErrInvalidS3aPath, errors.Cause(err).(Error).Kind)
+}
+
+func TestUploadCreateBucketIfMissing(t *testing.T) {
+	client, fake := newFakeS3Client()
+	client.Region = "eu-west-1"
+
+	bucketExists := false
+	fake.hooks["PutObject"] = func(r *request.Request) bool {
+		if !bucketExists {
+			r.Error = fakeS3Err(s3.ErrCodeNoSuchBucket, http.StatusNotFound)
+			return true
+		}
+		return false
+	}
+	fake.hooks["CreateBucket"] = func(r *request.Request) bool {
+		bucketExists = true
+		return false
+	}
+
+	err := client.UploadBytesToS3([]byte("data"), "object")
+	require.True(t, IsNoSuchBucketErr(err))
+	require.Equal(t, 0, fake.numCalls("CreateBucket"))
+
+	require.NoError(t, client.UploadBytesToS3WithOptions([]byte("data"), "object", UploadOptions{CreateBucketIfMissing: true}))
+	createInput := fake.lastInput("CreateBucket").(*s3.CreateBucketInput)
+	require.Equal(t, "eu-west-1", aws.StringValue(createInput.CreateBucketConfiguration.LocationConstraint))
+	encryptionInput := fake.lastInput("PutBucketEncryption").(*s3.PutBucketEncryptionInput)
+	require.Equal(t, s3.ServerSideEncryptionAes256, aws.StringValue(encryptionInput.ServerSideEncryptionConfiguration.Rules[0].ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+	require.Equal(t, 3, fake.numCalls("PutObject"))
+
+	obj, ok := fake.object("object")
+	require.True(t, ok)
+	require.Equal(t, []byte("data"), obj.data)
+}
+
+func TestCreateS3BucketEncryption(t *testing.T) {
+	client, fake := newFakeS3Client()
+
+	client.ServerSideEncryption = ServerSideEncryptionNone
+	require.NoError(t, client.CreateS3Bucket())
+	require.Equal(t, 1, fake.numCalls("CreateBucket"))
+	require.Equal(t, 0, fake.numCalls("PutBucketEncryption"))
+
+	client.ServerSideEncryption = s3.ServerSideEncryptionAwsKms
+	require.NoError(t, client.CreateS3Bucket())
+	encryptionInput := fake.lastInput("PutBucketEncryption").(*s3.PutBucketEncryptionInput)
+	require.Equal(t, s3.ServerSideEncryptionAwsKms, aws.StringValue(encryptionInput.ServerSideEncryptionConfiguration.Rules[0].ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+}
+
+func TestS3PartSizeAndConcurrency(t *testing.T) {
+	client, _ := newFakeS3Client()
+
+	uploader := client.s3Uploader()
+	require.Equal(t, int64(s3manager.DefaultUploadPartSize), uploader.PartSize)
+	require.Equal(t, s3manager.DefaultUploadConcurrency, uploader.Concurrency)
+	downloader := client.s3Downloader()
+	require.Equal(t, int64(s3manager.DefaultDownloadPartSize), downloader.PartSize)
+	require.Equal(t, s3manager.DefaultDownloadConcurrency, downloader.Concurrency)
+
+	client.S3PartSize = 32 * 1024 * 1024
+	client.S3Concurrency = 8
+
+	uploader = client.s3Uploader()
+	require.Equal(t, client.S3PartSize, uploader.PartSize)
+	require.Equal(t, client.S3Concurrency, uploader.Concurrency)
+	downloader = client.s3Downloader()
+	require.Equal(t, client.S3PartSize, downloader.PartSize)
+	require.Equal(t, client.S3Concurrency, downloader.Concurrency)
+
+	// per-call options take precedence
+	downloader = client.s3Downloader(func(d *s3manager.Downloader) {
+		d.Concurrency = 2
+	})
+	require.Equal(t, client.S3PartSize, downloader.PartSize)
+	require.Equal(t, 2, downloader.Concurrency)
+}
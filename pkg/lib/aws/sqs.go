@@ -0,0 +1,145 @@
+/*
+Copyright 2019 Cortex Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/cortexlabs/cortex/pkg/lib/errors"
+	"github.com/cortexlabs/cortex/pkg/lib/json"
+)
+
+var _s3ChangesReceiveErrorDelay = 5 * time.Second
+
+type s3EventNotification struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+
+	// set when the notification was delivered through SNS
+	Message string `json:"Message"`
+}
+
+// WatchS3Changes long-polls an SQS queue of the bucket's S3 events, and sends each changed key on the channel (read it until it's closed)
+func (c *Client) WatchS3Changes(queueURL string) (<-chan string, error) {
+	return c.WatchS3ChangesWithContext(context.Background(), queueURL)
+}
+
+// WatchS3ChangesWithContext is like WatchS3Changes, but stops polling and closes the channel when ctx is done
+func (c *Client) WatchS3ChangesWithContext(ctx context.Context, queueURL string) (<-chan string, error) {
+	if c.sqs == nil {
+		return nil, ErrorSQSNotConfigured()
+	}
+
+	_, err := c.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl: aws.String(queueURL),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, queueURL)
+	}
+
+	// the channel is also closed if the watcher stops on an error which isn't transient (e.g. the queue was deleted)
+	changedKeys := make(chan string)
+	go func() {
+		defer close(changedKeys)
+		if err := c.watchS3Changes(ctx, queueURL, changedKeys); err != nil {
+			errors.PrintError(err, "stopped watching for s3 changes")
+		}
+	}()
+
+	return changedKeys, nil
+}
+
+func (c *Client) watchS3Changes(ctx context.Context, queueURL string, changedKeys chan<- string) error {
+	for ctx.Err() == nil {
+		output, err := c.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if !isRetryableErr(err) {
+				return errors.Wrap(err, queueURL)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(_s3ChangesReceiveErrorDelay):
+			}
+			continue
+		}
+
+		for _, message := range output.Messages {
+			for _, key := range c.changedKeysFromMessage(aws.StringValue(message.Body)) {
+				select {
+				case changedKeys <- key:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+
+			// messages which aren't S3 events (e.g. s3:TestEvent) are deleted as well
+			_, err := c.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(queueURL),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+			if err != nil && ctx.Err() == nil && !isRetryableErr(err) {
+				return errors.Wrap(err, queueURL)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) changedKeysFromMessage(body string) []string {
+	var notification s3EventNotification
+	if err := json.Unmarshal([]byte(body), &notification); err != nil {
+		return nil
+	}
+	if len(notification.Records) == 0 && notification.Message != "" {
+		return c.changedKeysFromMessage(notification.Message)
+	}
+
+	var keys []string
+	for _, record := range notification.Records {
+		if record.S3.Bucket.Name != c.Bucket {
+			continue
+		}
+		// keys in event notifications are URL-encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
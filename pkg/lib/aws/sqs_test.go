@@ -0,0 +1,216 @@
+/*
+Copyright 2019 Cortex Labs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package aws
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cortexlabs/cortex/pkg/lib/errors"
+)
+
+type fakeSQS struct {
+	mux            sync.Mutex
+	pending        []*sqs.Message
+	deletedHandles []string
+	receiveErrs    []error // returned by the next ReceiveMessage calls, in order
+	deleteErr      error
+}
+
+func newFakeSQS(messages ...*sqs.Message) (*sqs.SQS, *fakeSQS) {
+	fake := &fakeSQS{pending: messages}
+
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(DefaultS3Region),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+
+	svc := sqs.New(sess)
+	svc.Handlers.Send.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.UnmarshalError.Clear()
+	svc.Handlers.Send.PushBack(fake.handle)
+
+	return svc, fake
+}
+
+func (f *fakeSQS) handle(r *request.Request) {
+	switch r.Params.(type) {
+	case *sqs.GetQueueAttributesInput:
+		return
+
+	case *sqs.ReceiveMessageInput:
+		f.mux.Lock()
+		if len(f.receiveErrs) > 0 {
+			r.Error = f.receiveErrs[0]
+			f.receiveErrs = f.receiveErrs[1:]
+			f.mux.Unlock()
+			return
+		}
+		messages := f.pending
+		f.pending = nil
+		f.mux.Unlock()
+
+		if len(messages) == 0 {
+			<-r.Context().Done()
+			r.Error = r.Context().Err()
+			return
+		}
+		// the SDK verifies the MD5 of each received message
+		for i, message := range messages {
+			md5Sum := md5.Sum([]byte(*message.Body))
+			message.MD5OfBody = aws.String(hex.EncodeToString(md5Sum[:]))
+			message.MessageId = aws.String(strconv.Itoa(i))
+		}
+		r.Data.(*sqs.ReceiveMessageOutput).Messages = messages
+
+	case *sqs.DeleteMessageInput:
+		f.mux.Lock()
+		defer f.mux.Unlock()
+		if f.deleteErr != nil {
+			r.Error = f.deleteErr
+			return
+		}
+		f.deletedHandles = append(f.deletedHandles, *r.Params.(*sqs.DeleteMessageInput).ReceiptHandle)
+	}
+}
+
+func s3EventMessage(t *testing.T, receiptHandle string, bucket string, keys ...string) *sqs.Message {
+	var records []interface{}
+	for _, key := range keys {
+		records = append(records, map[string]interface{}{
+			"eventName": "ObjectCreated:Put",
+			"s3": map[string]interface{}{
+				"bucket": map[string]interface{}{"name": bucket},
+				"object": map[string]interface{}{"key": key},
+			},
+		})
+	}
+	body, err := json.Marshal(map[string]interface{}{"Records": records})
+	require.NoError(t, err)
+
+	return &sqs.Message{
+		Body:          aws.String(string(body)),
+		ReceiptHandle: aws.String(receiptHandle),
+	}
+}
+
+func TestWatchS3Changes(t *testing.T) {
+	client, _ := newFakeS3Client()
+
+	snsBody, err := json.Marshal(map[string]string{
+		"Type":    "Notification",
+		"Message": *s3EventMessage(t, "", _testBucket, "sns/key.json").Body,
+	})
+	require.NoError(t, err)
+
+	sqsClient, fake := newFakeSQS(
+		s3EventMessage(t, "1", _testBucket, "apps/a.json", "apps/with+space.json"),
+		&sqs.Message{Body: aws.String(`{"Event":"s3:TestEvent"}`), ReceiptHandle: aws.String("2")},
+		s3EventMessage(t, "3", "other-bucket", "apps/other.json"),
+		&sqs.Message{Body: aws.String(string(snsBody)), ReceiptHandle: aws.String("4")},
+	)
+	client.sqs = sqsClient
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changedKeys, err := client.WatchS3ChangesWithContext(ctx, "https://sqs.us-west-2.amazonaws.com/123/queue")
+	require.NoError(t, err)
+
+	var keys []string
+	for i := 0; i < 3; i++ {
+		keys = append(keys, <-changedKeys)
+	}
+	require.Equal(t, []string{"apps/a.json", "apps/with space.json", "sns/key.json"}, keys)
+
+	cancel()
+	_, ok := <-changedKeys
+	require.False(t, ok)
+
+	fake.mux.Lock()
+	require.Equal(t, []string{"1", "2", "3", "4"}, fake.deletedHandles)
+	fake.mux.Unlock()
+
+	// the watcher stops when the context is done, even if its keys aren't being read
+	sqsClient, _ = newFakeSQS(s3EventMessage(t, "1", _testBucket, "a.json", "b.json"))
+	client.sqs = sqsClient
+	ctx, cancel = context.WithCancel(context.Background())
+	changedKeys, err = client.WatchS3ChangesWithContext(ctx, "https://sqs.us-west-2.amazonaws.com/123/queue")
+	require.NoError(t, err)
+	require.Equal(t, "a.json", <-changedKeys)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	_, ok = <-changedKeys
+	require.False(t, ok)
+}
+
+func TestWatchS3ChangesErrors(t *testing.T) {
+	defaultDelay := _s3ChangesReceiveErrorDelay
+	_s3ChangesReceiveErrorDelay = time.Millisecond
+	defer func() { _s3ChangesReceiveErrorDelay = defaultDelay }()
+
+	client, _ := newFakeS3Client()
+	client.sqs = nil
+	_, err := client.WatchS3Changes("https://sqs.us-west-2.amazonaws.com/123/queue")
+	require.Equal(t, ErrSQSNotConfigured, errors.Cause(err).(Error).Kind)
+
+	// transient errors are retried, others stop the watcher
+	sqsClient, fake := newFakeSQS()
+	fake.receiveErrs = []error{
+		awserr.NewRequestFailure(awserr.New("InternalError", "internal error", nil), http.StatusInternalServerError, ""),
+		awserr.NewRequestFailure(awserr.New("AWS.SimpleQueueService.NonExistentQueue", "the queue does not exist", nil), http.StatusBadRequest, ""),
+	}
+	client.sqs = sqsClient
+
+	changedKeys, err := client.WatchS3Changes("https://sqs.us-west-2.amazonaws.com/123/queue")
+	require.NoError(t, err)
+	for range changedKeys {
+	}
+	fake.mux.Lock()
+	require.Empty(t, fake.receiveErrs)
+	fake.mux.Unlock()
+
+	sqsClient, fake = newFakeSQS(s3EventMessage(t, "1", _testBucket, "a.json"))
+	fake.deleteErr = awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), http.StatusForbidden, "")
+	client.sqs = sqsClient
+
+	changedKeys, err = client.WatchS3Changes("https://sqs.us-west-2.amazonaws.com/123/queue")
+	require.NoError(t, err)
+	require.Equal(t, "a.json", <-changedKeys)
+	for range changedKeys {
+	}
+	fake.mux.Lock()
+	require.Empty(t, fake.deletedHandles)
+	fake.mux.Unlock()
+}
@@ -19,12 +19,21 @@ package json
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"path/filepath"
 
 	"github.com/cortexlabs/cortex/pkg/lib/errors"
 	"github.com/cortexlabs/cortex/pkg/lib/files"
 )
 
+// RawMessage is a raw encoded JSON value, which can be used to delay decoding
+type RawMessage = json.RawMessage
+
+// NewEncoder returns an encoder which writes each value to w as compact JSON, followed by a newline
+func NewEncoder(w io.Writer) *json.Encoder {
+	return json.NewEncoder(w)
+}
+
 func Marshal(obj interface{}) ([]byte, error) {
 	jsonBytes, err := json.MarshalIndent(obj, "", "  ")
 	if err != nil {
@@ -16,6 +16,10 @@ limitations under the License.
 
 package maps
 
+import (
+	"sort"
+)
+
 func StrMapKeys(myMap map[string]string) []string {
 	keys := make([]string, len(myMap))
 	i := 0
@@ -26,6 +30,12 @@ func StrMapKeys(myMap map[string]string) []string {
 	return keys
 }
 
+func StrMapSortedKeys(myMap map[string]string) []string {
+	keys := StrMapKeys(myMap)
+	sort.Strings(keys)
+	return keys
+}
+
 func StrMapValues(myMap map[string]string) []string {
 	values := make([]string, len(myMap))
 	i := 0
@@ -17,6 +17,8 @@ limitations under the License.
 package msgpack
 
 import (
+	"io"
+
 	"github.com/ugorji/go/codec"
 
 	"github.com/cortexlabs/cortex/pkg/lib/errors"
@@ -59,3 +61,13 @@ func Unmarshal(b []byte, obj interface{}) error {
 	dec := codec.NewDecoderBytes(b, &mh)
 	return dec.Decode(&obj)
 }
+
+// NewEncoder returns an encoder which writes consecutive msgpack values to w
+func NewEncoder(w io.Writer) *codec.Encoder {
+	return codec.NewEncoder(w, &mh)
+}
+
+// NewDecoder returns a decoder which reads consecutive msgpack values from r (Decode returns io.EOF after the last value)
+func NewDecoder(r io.Reader) *codec.Decoder {
+	return codec.NewDecoder(r, &mh)
+}
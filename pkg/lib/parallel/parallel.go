@@ -17,6 +17,8 @@ limitations under the License.
 package parallel
 
 import (
+	"sync"
+
 	"github.com/cortexlabs/cortex/pkg/lib/errors"
 )
 
@@ -52,3 +54,37 @@ func RunFirstErr(fns ...func() error) error {
 	errs := Run(fns...)
 	return errors.FirstError(errs...)
 }
+
+// RunWithLimit is like Run, but runs at most limit functions at a time
+func RunWithLimit(limit int, fns ...func() error) []error {
+	if len(fns) == 0 {
+		return nil
+	}
+	if limit <= 0 || limit >= len(fns) {
+		return Run(fns...)
+	}
+
+	errs := make([]error, len(fns))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+
+	for i := range fns {
+		i := i
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fns[i]()
+			<-sem
+		}()
+	}
+
+	wg.Wait()
+	return errs
+}
+
+// RunFirstErrWithLimit is like RunFirstErr, but runs at most limit functions at a time
+func RunFirstErrWithLimit(limit int, fns ...func() error) error {
+	errs := RunWithLimit(limit, fns...)
+	return errors.FirstError(errs...)
+}
@@ -176,7 +176,7 @@ func DeleteApp(appName string, keepCache bool) bool {
 	}
 
 	if !keepCache {
-		config.AWS.DeleteFromS3ByPrefix(filepath.Join(consts.AppsDir, appName), true)
+		config.AWS.DeleteFromS3ByPrefix(filepath.Join(consts.AppsDir, appName), true, false)
 	}
 
 	return wasDeployed